@@ -0,0 +1,166 @@
+package bitbucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fioncat/gitzombie/api"
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
+)
+
+func init() {
+	api.Register("bitbucket", New)
+}
+
+// The host of Bitbucket Cloud, other hosts are treated as Bitbucket Data
+// Center (Server).
+const cloudHost = "bitbucket.org"
+
+func New(remote *core.Remote) (api.Provider, error) {
+	if remote.Host == cloudHost {
+		url := remote.API
+		if url == "" {
+			url = "https://api.bitbucket.org/2.0"
+		}
+		return &Cloud{
+			cli:    newClient(remote, url),
+			remote: remote,
+		}, nil
+	}
+
+	url := remote.API
+	if url == "" {
+		url = fmt.Sprintf("https://%s/rest/api/1.0", remote.Host)
+	}
+	return &Server{
+		cli:    newClient(remote, url),
+		remote: remote,
+	}, nil
+}
+
+type client struct {
+	base string
+
+	httpCli *http.Client
+
+	// The token can be an access token, or "username:app_password" to use
+	// basic auth.
+	token string
+}
+
+func newClient(remote *core.Remote, base string) *client {
+	return &client{
+		base:    strings.TrimSuffix(base, "/"),
+		httpCli: api.NewHTTPClient(remote),
+		token:   remote.Token,
+	}
+}
+
+type responseError struct {
+	status int
+	msg    string
+}
+
+func (err *responseError) Error() string {
+	if err.msg == "" {
+		return fmt.Sprintf("bad status %d", err.status)
+	}
+	return fmt.Sprintf("bad status %d: %s", err.status, err.msg)
+}
+
+func (c *client) request(method, path string, body any) (*http.Response, error) {
+	url := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		url = c.base + path
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(api.Context(), method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		if user, password, ok := strings.Cut(c.token, ":"); ok {
+			req.SetBasicAuth(user, password)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+	}
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &responseError{
+			status: resp.StatusCode,
+			msg:    strings.TrimSpace(string(data)),
+		}
+	}
+	return resp, nil
+}
+
+func (c *client) do(method, path string, body, out any) error {
+	resp, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) get(path string, out any) error {
+	return c.do(http.MethodGet, path, nil, out)
+}
+
+func isNotFound(err error) bool {
+	if respErr, ok := err.(*responseError); ok {
+		return respErr.status == http.StatusNotFound
+	}
+	return false
+}
+
+func wrapErr(name, provider string, err error) error {
+	if isNotFound(err) {
+		return fmt.Errorf("cannot find %q in %s", name, provider)
+	}
+	return err
+}
+
+func parseName(name string) (string, string, error) {
+	tmp := strings.Split(name, "/")
+	if len(tmp) != 2 {
+		return "", "", fmt.Errorf("invalid Bitbucket repo name %q", name)
+	}
+	return tmp[0], tmp[1], nil
+}
+
+func pageLimit() int {
+	limit := config.Get().SearchLimit
+	// Bitbucket Cloud does not allow page length bigger than 100.
+	if limit > 100 {
+		limit = 100
+	}
+	return limit
+}
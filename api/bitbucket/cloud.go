@@ -0,0 +1,289 @@
+package bitbucket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/api"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+type cloudLink struct {
+	Href string `json:"href"`
+}
+
+type cloudRepository struct {
+	FullName string `json:"full_name"`
+
+	Links struct {
+		HTML cloudLink `json:"html"`
+	} `json:"links"`
+
+	MainBranch *struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+
+	Parent *cloudRepository `json:"parent"`
+}
+
+type cloudPullRequest struct {
+	Links struct {
+		HTML cloudLink `json:"html"`
+	} `json:"links"`
+}
+
+type cloudDownload struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	Links struct {
+		Self cloudLink `json:"self"`
+	} `json:"links"`
+}
+
+type cloudPage[T any] struct {
+	Values []*T   `json:"values"`
+	Next   string `json:"next"`
+}
+
+// Cloud is the provider for Bitbucket Cloud (bitbucket.org). The group is
+// the workspace, and the release is the repository downloads.
+type Cloud struct {
+	cli    *client
+	remote *core.Remote
+}
+
+func (p *Cloud) Name() string { return "Bitbucket" }
+
+func (p *Cloud) SearchRepositories(group, query string) ([]*api.Repository, error) {
+	params := url.Values{}
+	params.Set("pagelen", fmt.Sprint(pageLimit()))
+	if query != "" {
+		params.Set("q", fmt.Sprintf("name ~ %q", query))
+	}
+
+	var path string
+	if group != "" {
+		path = fmt.Sprintf("/repositories/%s", url.PathEscape(group))
+	} else {
+		// Without workspace, only search repositories that current user
+		// is a member of.
+		path = "/repositories"
+		params.Set("role", "member")
+	}
+
+	var page cloudPage[cloudRepository]
+	err := p.cli.get(path+"?"+params.Encode(), &page)
+	if err != nil {
+		return nil, wrapErr(group, p.Name(), err)
+	}
+	if len(page.Values) == 0 {
+		return nil, api.ErrNoResult
+	}
+
+	repos := make([]*api.Repository, len(page.Values))
+	for i, cloudRepo := range page.Values {
+		repos[i] = p.convertRepo(cloudRepo)
+	}
+	return repos, nil
+}
+
+func (p *Cloud) ListRepositories(group string) ([]*api.Repository, error) {
+	path := fmt.Sprintf("/repositories/%s?pagelen=%d", url.PathEscape(group), pageLimit())
+	var repos []*api.Repository
+	for path != "" {
+		var page cloudPage[cloudRepository]
+		err := p.cli.get(path, &page)
+		if err != nil {
+			return nil, wrapErr(group, p.Name(), err)
+		}
+		for _, cloudRepo := range page.Values {
+			repos = append(repos, p.convertRepo(cloudRepo))
+		}
+		path = page.Next
+		time.Sleep(time.Millisecond * 50)
+	}
+	return repos, nil
+}
+
+func (p *Cloud) GetRepository(name string) (*api.Repository, error) {
+	cloudRepo, err := p.getRepository(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.convertRepo(cloudRepo), nil
+}
+
+func (p *Cloud) getRepository(name string) (*cloudRepository, error) {
+	workspace, slug, err := parseName(name)
+	if err != nil {
+		return nil, err
+	}
+	var cloudRepo cloudRepository
+	path := fmt.Sprintf("/repositories/%s/%s", url.PathEscape(workspace), url.PathEscape(slug))
+	err = p.cli.get(path, &cloudRepo)
+	if err != nil {
+		return nil, wrapErr(name, p.Name(), err)
+	}
+	return &cloudRepo, nil
+}
+
+func (p *Cloud) GetMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	targetRepo := repo.Name
+	conds := []string{
+		`state = "OPEN"`,
+		fmt.Sprintf("source.branch.name = %q", opts.SourceBranch),
+		fmt.Sprintf("destination.branch.name = %q", opts.TargetBranch),
+	}
+	if opts.Upstream != nil {
+		// The pull request to upstream belongs to upstream repo, use source
+		// repository to find the one created by us.
+		targetRepo = opts.Upstream.Name
+		conds = append(conds, fmt.Sprintf("source.repository.full_name = %q", repo.Name))
+	}
+
+	params := url.Values{}
+	params.Set("q", strings.Join(conds, " AND "))
+	path := fmt.Sprintf("/repositories/%s/pullrequests?%s", targetRepo, params.Encode())
+
+	var page cloudPage[cloudPullRequest]
+	err := p.cli.get(path, &page)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(page.Values) == 0 {
+		return "", nil
+	}
+	return page.Values[0].Links.HTML.Href, nil
+}
+
+func (p *Cloud) CreateMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	targetRepo := repo.Name
+	if opts.Upstream != nil {
+		targetRepo = opts.Upstream.Name
+	}
+	body := map[string]any{
+		"title":       opts.Title,
+		"description": opts.Body,
+		"source": map[string]any{
+			"branch":     map[string]any{"name": opts.SourceBranch},
+			"repository": map[string]any{"full_name": repo.Name},
+		},
+		"destination": map[string]any{
+			"branch": map[string]any{"name": opts.TargetBranch},
+		},
+	}
+
+	var pr cloudPullRequest
+	path := fmt.Sprintf("/repositories/%s/pullrequests", targetRepo)
+	err := p.cli.do(http.MethodPost, path, body, &pr)
+	if err != nil {
+		return "", err
+	}
+	return pr.Links.HTML.Href, nil
+}
+
+// Bitbucket Cloud does not have release, we treat the repository downloads
+// as a single release. The tag is used to filter download files.
+const cloudDownloadsName = "downloads"
+
+func (p *Cloud) GetRelease(repo *core.Repository, tag string) (*api.Release, error) {
+	release, err := p.getDownloads(repo)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		return release, nil
+	}
+
+	files := make([]*api.ReleaseFile, 0, len(release.Files))
+	for _, file := range release.Files {
+		if strings.Contains(file.Name, tag) {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("cannot find download file for %q in Bitbucket", tag)
+	}
+	release.Name = tag
+	release.Tag = tag
+	release.Files = files
+	return release, nil
+}
+
+func (p *Cloud) ListReleases(repo *core.Repository) ([]*api.Release, error) {
+	release, err := p.getDownloads(repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(release.Files) == 0 {
+		return nil, nil
+	}
+	return []*api.Release{release}, nil
+}
+
+func (p *Cloud) DownloadReleaseFile(repo *core.Repository, file *api.ReleaseFile) (io.ReadCloser, error) {
+	href, ok := file.ID.(string)
+	if !ok || href == "" {
+		return nil, fmt.Errorf("invalid download file %q", file.Name)
+	}
+	resp, err := p.cli.request(http.MethodGet, href, nil)
+	if err != nil {
+		return nil, wrapErr(file.Name, p.Name(), err)
+	}
+	return resp.Body, nil
+}
+
+func (p *Cloud) getDownloads(repo *core.Repository) (*api.Release, error) {
+	cloudRepo, err := p.getRepository(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	release := &api.Release{
+		Name:   cloudDownloadsName,
+		Tag:    cloudDownloadsName,
+		WebURL: cloudRepo.Links.HTML.Href + "/downloads",
+	}
+
+	path := fmt.Sprintf("/repositories/%s/downloads?pagelen=%d", repo.Name, pageLimit())
+	for path != "" {
+		var page cloudPage[cloudDownload]
+		err = p.cli.get(path, &page)
+		if err != nil {
+			return nil, errors.Trace(wrapErr(repo.Name, p.Name(), err), "list downloads")
+		}
+		for _, download := range page.Values {
+			release.Files = append(release.Files, &api.ReleaseFile{
+				ID:   download.Links.Self.Href,
+				Name: download.Name,
+				Size: download.Size,
+			})
+		}
+		path = page.Next
+	}
+	return release, nil
+}
+
+func (p *Cloud) convertRepo(cloudRepo *cloudRepository) *api.Repository {
+	repo := &api.Repository{
+		Name:   cloudRepo.FullName,
+		Remote: p.remote,
+
+		WebURL: cloudRepo.Links.HTML.Href,
+	}
+	if cloudRepo.MainBranch != nil {
+		repo.DefaultBranch = cloudRepo.MainBranch.Name
+	}
+	if cloudRepo.Parent != nil {
+		repo.Upstream = p.convertRepo(cloudRepo.Parent)
+	}
+	return repo
+}
@@ -0,0 +1,275 @@
+package bitbucket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/api"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+type serverLink struct {
+	Href string `json:"href"`
+}
+
+type serverProject struct {
+	Key string `json:"key"`
+}
+
+type serverRepository struct {
+	Slug string `json:"slug"`
+
+	Project serverProject `json:"project"`
+
+	Archived bool `json:"archived"`
+
+	Links struct {
+		Self []serverLink `json:"self"`
+	} `json:"links"`
+
+	Origin *serverRepository `json:"origin"`
+}
+
+type serverRef struct {
+	ID        string `json:"id"`
+	DisplayID string `json:"displayId"`
+
+	Repository *serverRepository `json:"repository"`
+}
+
+type serverPullRequest struct {
+	FromRef serverRef `json:"fromRef"`
+	ToRef   serverRef `json:"toRef"`
+
+	Links struct {
+		Self []serverLink `json:"self"`
+	} `json:"links"`
+}
+
+type serverPage[T any] struct {
+	Values []*T `json:"values"`
+
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// Server is the provider for Bitbucket Data Center (Server). The group is
+// the project key.
+type Server struct {
+	cli    *client
+	remote *core.Remote
+}
+
+func (p *Server) Name() string { return "Bitbucket Server" }
+
+func (p *Server) SearchRepositories(group, query string) ([]*api.Repository, error) {
+	var path string
+	params := url.Values{}
+	params.Set("limit", fmt.Sprint(pageLimit()))
+	if group != "" {
+		path = fmt.Sprintf("/projects/%s/repos", url.PathEscape(group))
+	} else {
+		path = "/repos"
+		if query != "" {
+			params.Set("name", query)
+		}
+	}
+
+	var page serverPage[serverRepository]
+	err := p.cli.get(path+"?"+params.Encode(), &page)
+	if err != nil {
+		return nil, wrapErr(group, p.Name(), err)
+	}
+
+	repos := make([]*api.Repository, 0, len(page.Values))
+	for _, serverRepo := range page.Values {
+		// The project repos API does not support filtering by name, do it
+		// ourselves.
+		if group != "" && query != "" && !strings.Contains(serverRepo.Slug, query) {
+			continue
+		}
+		repos = append(repos, p.convertRepo(serverRepo))
+	}
+	if len(repos) == 0 {
+		return nil, api.ErrNoResult
+	}
+	return repos, nil
+}
+
+func (p *Server) ListRepositories(group string) ([]*api.Repository, error) {
+	var repos []*api.Repository
+	var start int
+	for {
+		path := fmt.Sprintf("/projects/%s/repos?start=%d&limit=%d",
+			url.PathEscape(group), start, pageLimit())
+		var page serverPage[serverRepository]
+		err := p.cli.get(path, &page)
+		if err != nil {
+			return nil, wrapErr(group, p.Name(), err)
+		}
+		for _, serverRepo := range page.Values {
+			repos = append(repos, p.convertRepo(serverRepo))
+		}
+		if page.IsLastPage || len(page.Values) == 0 {
+			return repos, nil
+		}
+		start = page.NextPageStart
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func (p *Server) GetRepository(name string) (*api.Repository, error) {
+	project, slug, err := parseName(name)
+	if err != nil {
+		return nil, err
+	}
+	repoPath := p.repoPath(project, slug)
+
+	var serverRepo serverRepository
+	err = p.cli.get(repoPath, &serverRepo)
+	if err != nil {
+		return nil, wrapErr(name, p.Name(), err)
+	}
+	repo := p.convertRepo(&serverRepo)
+
+	var branch serverRef
+	err = p.cli.get(repoPath+"/branches/default", &branch)
+	if err != nil && !isNotFound(err) {
+		return nil, errors.Trace(err, "get default branch")
+	}
+	repo.DefaultBranch = branch.DisplayID
+
+	return repo, nil
+}
+
+func (p *Server) GetMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	targetRepo := repo.Name
+	params := url.Values{}
+	params.Set("state", "OPEN")
+	if opts.Upstream != nil {
+		// The pull request to upstream belongs to upstream repo, we search
+		// incoming pull requests to target branch and match the source
+		// repository.
+		targetRepo = opts.Upstream.Name
+		params.Set("direction", "INCOMING")
+		params.Set("at", "refs/heads/"+opts.TargetBranch)
+	} else {
+		params.Set("direction", "OUTGOING")
+		params.Set("at", "refs/heads/"+opts.SourceBranch)
+	}
+
+	project, slug, err := parseName(targetRepo)
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("%s/pull-requests?%s", p.repoPath(project, slug), params.Encode())
+
+	var page serverPage[serverPullRequest]
+	err = p.cli.get(path, &page)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, pr := range page.Values {
+		if pr.FromRef.DisplayID != opts.SourceBranch || pr.ToRef.DisplayID != opts.TargetBranch {
+			continue
+		}
+		if opts.Upstream != nil && p.repoName(pr.FromRef.Repository) != repo.Name {
+			continue
+		}
+		return p.webURL(pr.Links.Self), nil
+	}
+	return "", nil
+}
+
+func (p *Server) CreateMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	targetRepo := repo.Name
+	if opts.Upstream != nil {
+		targetRepo = opts.Upstream.Name
+	}
+	srcProject, srcSlug, err := parseName(repo.Name)
+	if err != nil {
+		return "", err
+	}
+	tarProject, tarSlug, err := parseName(targetRepo)
+	if err != nil {
+		return "", err
+	}
+
+	body := map[string]any{
+		"title":       opts.Title,
+		"description": opts.Body,
+		"fromRef":     p.ref(srcProject, srcSlug, opts.SourceBranch),
+		"toRef":       p.ref(tarProject, tarSlug, opts.TargetBranch),
+	}
+
+	var pr serverPullRequest
+	path := p.repoPath(tarProject, tarSlug) + "/pull-requests"
+	err = p.cli.do(http.MethodPost, path, body, &pr)
+	if err != nil {
+		return "", err
+	}
+	return p.webURL(pr.Links.Self), nil
+}
+
+func (p *Server) GetRelease(repo *core.Repository, tag string) (*api.Release, error) {
+	return nil, errors.New("sorry, Bitbucket Server does not support release")
+}
+
+func (p *Server) ListReleases(repo *core.Repository) ([]*api.Release, error) {
+	return nil, errors.New("sorry, Bitbucket Server does not support release")
+}
+
+func (p *Server) DownloadReleaseFile(repo *core.Repository, file *api.ReleaseFile) (io.ReadCloser, error) {
+	return nil, errors.New("sorry, Bitbucket Server does not support release")
+}
+
+func (p *Server) repoPath(project, slug string) string {
+	return fmt.Sprintf("/projects/%s/repos/%s", url.PathEscape(project), url.PathEscape(slug))
+}
+
+func (p *Server) ref(project, slug, branch string) map[string]any {
+	return map[string]any{
+		"id": "refs/heads/" + branch,
+		"repository": map[string]any{
+			"slug":    slug,
+			"project": map[string]any{"key": project},
+		},
+	}
+}
+
+func (p *Server) repoName(serverRepo *serverRepository) string {
+	if serverRepo == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", serverRepo.Project.Key, serverRepo.Slug)
+}
+
+func (p *Server) webURL(links []serverLink) string {
+	if len(links) == 0 {
+		return ""
+	}
+	return links[0].Href
+}
+
+func (p *Server) convertRepo(serverRepo *serverRepository) *api.Repository {
+	repo := &api.Repository{
+		Name:   p.repoName(serverRepo),
+		Remote: p.remote,
+
+		WebURL: p.webURL(serverRepo.Links.Self),
+
+		Archived: serverRepo.Archived,
+	}
+	if serverRepo.Origin != nil {
+		repo.Upstream = p.convertRepo(serverRepo.Origin)
+	}
+	return repo
+}
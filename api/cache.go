@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// RefreshCache ignores the cached repositories and always calls API.
+var RefreshCache bool
+
+type cacheRepository struct {
+	Name string
+
+	WebURL string
+
+	DefaultBranch string
+
+	Upstream *cacheRepository
+
+	Archived bool
+}
+
+type cacheGroup struct {
+	Time  int64
+	Repos []*cacheRepository
+}
+
+type searchKey struct {
+	Group string
+	Query string
+}
+
+type repositoryCache struct {
+	remote string
+
+	Groups   map[string]*cacheGroup
+	Searches map[searchKey]*cacheGroup
+}
+
+func cachePath(remote string) string {
+	return config.GetLocalDir("cache", "repos", remote)
+}
+
+func readRepositoryCache(remote string) (*repositoryCache, error) {
+	c := &repositoryCache{remote: remote}
+	defer c.init()
+	data, err := os.ReadFile(cachePath(remote))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, errors.Trace(err, "read cache file")
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err = decoder.Decode(c)
+	if err != nil {
+		// The broken cache can be rebuilt, no need to return error.
+		c.Groups, c.Searches = nil, nil
+	}
+	return c, nil
+}
+
+func (c *repositoryCache) init() {
+	if c.Groups == nil {
+		c.Groups = make(map[string]*cacheGroup)
+	}
+	if c.Searches == nil {
+		c.Searches = make(map[searchKey]*cacheGroup)
+	}
+}
+
+func (c *repositoryCache) write() error {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	err := encoder.Encode(c)
+	if err != nil {
+		return errors.Trace(err, "encode cache")
+	}
+
+	// Other processes might be writing the cache at the same time, write to
+	// a temporary file and rename it, so the cache file is never partial.
+	path := cachePath(c.remote)
+	dir := filepath.Dir(path)
+	err = osutil.EnsureDir(dir)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Trace(err, "create cache file")
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Trace(err, "write cache file")
+	}
+	return errors.Trace(os.Rename(file.Name(), path), "replace cache file")
+}
+
+func (c *repositoryCache) get(remote *core.Remote, group string) []*Repository {
+	return c.Groups[group].convert(remote)
+}
+
+func (c *repositoryCache) set(group string, repos []*Repository) {
+	c.Groups[group] = newCacheGroup(repos)
+}
+
+func (c *repositoryCache) getSearch(remote *core.Remote, group, query string) []*Repository {
+	return c.Searches[searchKey{Group: group, Query: query}].convert(remote)
+}
+
+func (c *repositoryCache) setSearch(group, query string, repos []*Repository) {
+	c.Searches[searchKey{Group: group, Query: query}] = newCacheGroup(repos)
+}
+
+func newCacheGroup(repos []*Repository) *cacheGroup {
+	cacheRepos := make([]*cacheRepository, len(repos))
+	for i, repo := range repos {
+		cacheRepos[i] = newCacheRepository(repo)
+	}
+	return &cacheGroup{
+		Time:  time.Now().Unix(),
+		Repos: cacheRepos,
+	}
+}
+
+// convert returns nil if g is nil or expired.
+func (g *cacheGroup) convert(remote *core.Remote) []*Repository {
+	if g == nil {
+		return nil
+	}
+	ttl := int64(config.Get().CacheTTL)
+	if time.Now().Unix()-g.Time > ttl {
+		return nil
+	}
+	repos := make([]*Repository, len(g.Repos))
+	for i, cacheRepo := range g.Repos {
+		repos[i] = cacheRepo.convert(remote)
+	}
+	return repos
+}
+
+func newCacheRepository(repo *Repository) *cacheRepository {
+	cacheRepo := &cacheRepository{
+		Name:          repo.Name,
+		WebURL:        repo.WebURL,
+		DefaultBranch: repo.DefaultBranch,
+		Archived:      repo.Archived,
+	}
+	if repo.Upstream != nil {
+		cacheRepo.Upstream = newCacheRepository(repo.Upstream)
+	}
+	return cacheRepo
+}
+
+func (cacheRepo *cacheRepository) convert(remote *core.Remote) *Repository {
+	repo := &Repository{
+		Name:          cacheRepo.Name,
+		Remote:        remote,
+		WebURL:        cacheRepo.WebURL,
+		DefaultBranch: cacheRepo.DefaultBranch,
+		Archived:      cacheRepo.Archived,
+	}
+	if cacheRepo.Upstream != nil {
+		repo.Upstream = cacheRepo.Upstream.convert(remote)
+	}
+	return repo
+}
+
+// ListRepos lists the repositories in the group, the result will be cached
+// until the ttl expires.
+func ListRepos(remote *core.Remote, group string) ([]*Repository, error) {
+	group = strings.Trim(group, "/")
+	cache, err := readRepositoryCache(remote.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !RefreshCache {
+		repos := cache.get(remote, group)
+		if repos != nil {
+			return repos, nil
+		}
+	}
+
+	var repos []*Repository
+	err = Exec("list repos", remote, func(p Provider) error {
+		repos, err = p.ListRepositories(group)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.set(group, repos)
+	return repos, cache.write()
+}
+
+// searchRepos calls the provider to search repositories, the result will be
+// cached by group and query until the ttl expires.
+func searchRepos(op string, remote *core.Remote, group, query string) ([]*Repository, error) {
+	cache, err := readRepositoryCache(remote.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !RefreshCache {
+		repos := cache.getSearch(remote, group, query)
+		if repos != nil {
+			return repos, nil
+		}
+	}
+
+	var repos []*Repository
+	err = Exec(op, remote, func(p Provider) error {
+		repos, err = p.SearchRepositories(group, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.setSearch(group, query, repos)
+	return repos, cache.write()
+}
+
+// ListCachedRepoNames returns the names of all cached repositories for the
+// remote, no matter whether they are expired. It never calls API, so it is
+// safe to use in completion.
+func ListCachedRepoNames(remote string) []string {
+	cache, err := readRepositoryCache(remote)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, g := range cache.Groups {
+		for _, repo := range g.Repos {
+			names = append(names, repo.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
+)
+
+func TestRepositoryCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &core.Remote{Name: "cache-test"}
+
+	// Concurrent writers should never leave a partial cache file.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache, err := readRepositoryCache(remote.Name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			name := fmt.Sprintf("fioncat/repo%d", i)
+			cache.set("fioncat", []*Repository{{Name: name}})
+			cache.setSearch("fioncat", "repo", []*Repository{{Name: name}})
+			err = cache.write()
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cache, err := readRepositoryCache(remote.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repos := cache.get(remote, "fioncat"); len(repos) != 1 {
+		t.Fatalf("unexpected cached group %v", repos)
+	}
+	if repos := cache.getSearch(remote, "fioncat", "repo"); len(repos) != 1 {
+		t.Fatalf("unexpected cached search %v", repos)
+	}
+	if repos := cache.getSearch(remote, "fioncat", "other"); repos != nil {
+		t.Fatalf("unexpected cached search %v", repos)
+	}
+}
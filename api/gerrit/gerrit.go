@@ -0,0 +1,379 @@
+package gerrit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/fioncat/gitzombie/api"
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/git"
+)
+
+func init() {
+	api.Register("gerrit", New)
+}
+
+// Gerrit prefixes every JSON response with this magic line to prevent XSSI.
+const jsonPrefix = ")]}'"
+
+type projectInfo struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type changeInfo struct {
+	Number   int    `json:"_number"`
+	ChangeID string `json:"change_id"`
+	Project  string `json:"project"`
+	Branch   string `json:"branch"`
+}
+
+type Provider struct {
+	base string
+
+	httpCli *http.Client
+
+	user     string
+	password string
+
+	remote *core.Remote
+}
+
+func New(remote *core.Remote) (api.Provider, error) {
+	base := remote.API
+	if base == "" {
+		base = fmt.Sprintf("https://%s", remote.Host)
+	}
+	p := &Provider{
+		base:    strings.TrimSuffix(base, "/"),
+		httpCli: api.NewHTTPClient(remote),
+		remote:  remote,
+	}
+	// The token is the Gerrit HTTP password, the username defaults to the
+	// remote user. Use "username:password" to override it.
+	if remote.Token != "" {
+		if user, password, ok := strings.Cut(remote.Token, ":"); ok {
+			p.user, p.password = user, password
+		} else {
+			p.user, p.password = remote.User, remote.Token
+		}
+	}
+	return p, nil
+}
+
+func (p *Provider) Name() string { return "Gerrit" }
+
+// CommitMerge marks that the change title and body come from commit message.
+func (p *Provider) CommitMerge() {}
+
+func (p *Provider) SearchRepositories(group, query string) ([]*api.Repository, error) {
+	params := url.Values{}
+	params.Set("n", fmt.Sprint(config.Get().SearchLimit))
+	if group != "" {
+		params.Set("p", group+"/")
+	} else if query != "" {
+		params.Set("m", query)
+	}
+
+	prjs, err := p.listProjects(params)
+	if err != nil {
+		return nil, p.wrapErr(group, err)
+	}
+
+	repos := make([]*api.Repository, 0, len(prjs))
+	for _, prj := range prjs {
+		// The prefix and substring filters cannot be used together, filter
+		// query in group ourselves.
+		if group != "" && query != "" && !strings.Contains(prj.Name, query) {
+			continue
+		}
+		repos = append(repos, p.convertRepo(prj))
+	}
+	if len(repos) == 0 {
+		return nil, api.ErrNoResult
+	}
+	return repos, nil
+}
+
+func (p *Provider) ListRepositories(group string) ([]*api.Repository, error) {
+	limit := config.Get().SearchLimit
+	var repos []*api.Repository
+	for skip := 0; ; skip += limit {
+		params := url.Values{}
+		params.Set("p", strings.Trim(group, "/")+"/")
+		params.Set("n", fmt.Sprint(limit))
+		params.Set("S", fmt.Sprint(skip))
+		prjs, err := p.listProjects(params)
+		if err != nil {
+			return nil, p.wrapErr(group, err)
+		}
+		for _, prj := range prjs {
+			repos = append(repos, p.convertRepo(prj))
+		}
+		if len(prjs) < limit {
+			return repos, nil
+		}
+	}
+}
+
+func (p *Provider) GetRepository(name string) (*api.Repository, error) {
+	var prj projectInfo
+	path := "/projects/" + url.PathEscape(name)
+	err := p.get(path, &prj)
+	if err != nil {
+		return nil, p.wrapErr(name, err)
+	}
+	if prj.Name == "" {
+		prj.Name = name
+	}
+	repo := p.convertRepo(&prj)
+
+	var head string
+	err = p.get(path+"/HEAD", &head)
+	if err != nil {
+		return nil, errors.Trace(err, "get HEAD")
+	}
+	repo.DefaultBranch = strings.TrimPrefix(head, "refs/heads/")
+	return repo, nil
+}
+
+func (p *Provider) GetMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	if opts.Upstream != nil {
+		return "", errors.New("Gerrit does not support merge to upstream repo")
+	}
+	changeID, err := getChangeID(repo, opts.SourceBranch)
+	if err != nil {
+		return "", err
+	}
+	if changeID == "" {
+		return "", nil
+	}
+	change, err := p.getChange(repo, changeID, opts.TargetBranch)
+	if err != nil || change == nil {
+		return "", err
+	}
+	return p.changeURL(change), nil
+}
+
+func (p *Provider) CreateMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
+	if opts.Upstream != nil {
+		return "", errors.New("Gerrit does not support merge to upstream repo")
+	}
+
+	pushOpts := make([]string, 0, len(opts.Reviewers)+1)
+	if opts.Topic != "" {
+		pushOpts = append(pushOpts, "topic="+opts.Topic)
+	}
+	for _, reviewer := range opts.Reviewers {
+		pushOpts = append(pushOpts, "r="+reviewer)
+	}
+	ref := "refs/for/" + opts.TargetBranch
+	if len(pushOpts) > 0 {
+		ref += "%" + strings.Join(pushOpts, ",")
+	}
+	refspec := fmt.Sprintf("%s:%s", opts.SourceBranch, ref)
+	err := git.Exec([]string{"push", p.pushRemote(repo, opts.SourceBranch), refspec}, &git.Options{
+		Path: repo.Path,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	changeID, err := getChangeID(repo, opts.SourceBranch)
+	if err != nil {
+		return "", err
+	}
+	if changeID == "" {
+		return "", errors.New("cannot find Change-Id in commit message, please install the Gerrit commit-msg hook")
+	}
+	change, err := p.getChange(repo, changeID, opts.TargetBranch)
+	if err != nil {
+		return "", err
+	}
+	if change == nil {
+		return "", fmt.Errorf("cannot find change %s after pushing", changeID)
+	}
+	return p.changeURL(change), nil
+}
+
+func (p *Provider) GetRelease(repo *core.Repository, tag string) (*api.Release, error) {
+	return nil, errors.New("sorry, Gerrit does not support release")
+}
+
+func (p *Provider) ListReleases(repo *core.Repository) ([]*api.Release, error) {
+	return nil, errors.New("sorry, Gerrit does not support release")
+}
+
+func (p *Provider) DownloadReleaseFile(repo *core.Repository, file *api.ReleaseFile) (io.ReadCloser, error) {
+	return nil, errors.New("sorry, Gerrit does not support release")
+}
+
+func (p *Provider) getChange(repo *core.Repository, changeID, branch string) (*changeInfo, error) {
+	query := fmt.Sprintf("change:%s status:open project:%s branch:%s", changeID, repo.Name, branch)
+	var changes []*changeInfo
+	err := p.get("/changes/?q="+url.QueryEscape(query), &changes)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return changes[0], nil
+}
+
+func (p *Provider) changeURL(change *changeInfo) string {
+	return fmt.Sprintf("%s/c/%s/+/%d", p.base, change.Project, change.Number)
+}
+
+func (p *Provider) listProjects(params url.Values) ([]*projectInfo, error) {
+	prjMap := make(map[string]*projectInfo)
+	err := p.get("/projects/?"+params.Encode(), &prjMap)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(prjMap))
+	for name := range prjMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prjs := make([]*projectInfo, 0, len(names))
+	for _, name := range names {
+		prj := prjMap[name]
+		if prj.State == "HIDDEN" {
+			continue
+		}
+		prj.Name = name
+		prjs = append(prjs, prj)
+	}
+	return prjs, nil
+}
+
+type responseError struct {
+	status int
+	msg    string
+}
+
+func (err *responseError) Error() string {
+	if err.msg == "" {
+		return fmt.Sprintf("bad status %d", err.status)
+	}
+	return fmt.Sprintf("bad status %d: %s", err.status, err.msg)
+}
+
+func (p *Provider) get(path string, out any) error {
+	// Authenticated REST endpoints are prefixed with "/a".
+	if p.password != "" {
+		path = "/a" + path
+	}
+	req, err := http.NewRequestWithContext(api.Context(), http.MethodGet, p.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.password != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+
+	resp, err := p.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err, "read response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &responseError{
+			status: resp.StatusCode,
+			msg:    strings.TrimSpace(string(data)),
+		}
+	}
+	data = bytes.TrimPrefix(data, []byte(jsonPrefix))
+	return json.Unmarshal(data, out)
+}
+
+func (p *Provider) wrapErr(name string, err error) error {
+	if respErr, ok := err.(*responseError); ok && respErr.status == http.StatusNotFound {
+		return fmt.Errorf("cannot find %q in Gerrit", name)
+	}
+	return err
+}
+
+func (p *Provider) convertRepo(prj *projectInfo) *api.Repository {
+	return &api.Repository{
+		Name:   prj.Name,
+		Remote: p.remote,
+
+		WebURL: fmt.Sprintf("%s/admin/repos/%s", p.base, prj.Name),
+
+		Archived: prj.State == "READ_ONLY",
+	}
+}
+
+// pushRemote returns the git remote to push change: the tracked remote of
+// branch, or the remote whose url is the clone url of repo. Default is
+// "origin".
+func (p *Provider) pushRemote(repo *core.Repository, branch string) string {
+	opts := &git.Options{
+		QuietCmd:    true,
+		QuietStderr: true,
+
+		Path: repo.Path,
+	}
+	remote, err := git.Output([]string{"config", fmt.Sprintf("branch.%s.remote", branch)}, opts)
+	if err == nil && remote != "" && remote != "." {
+		return remote
+	}
+
+	url, err := p.remote.GetCloneURL(repo)
+	if err != nil {
+		return "origin"
+	}
+	names, err := git.ListRemotes(opts)
+	if err != nil {
+		return "origin"
+	}
+	for _, name := range names {
+		remoteURL, err := git.Output([]string{"remote", "get-url", name}, opts)
+		if err == nil && remoteURL == url {
+			return name
+		}
+	}
+	return "origin"
+}
+
+// getChangeID reads the Change-Id footer from the last commit message of
+// the branch.
+func getChangeID(repo *core.Repository, branch string) (string, error) {
+	msg, err := git.Output([]string{"log", "-1", "--format=%B", branch}, &git.Options{
+		QuietCmd:    true,
+		QuietStderr: true,
+
+		Path: repo.Path,
+	})
+	if err != nil {
+		return "", errors.Trace(err, "read commit message")
+	}
+	var changeID string
+	scanner := bufio.NewScanner(strings.NewReader(msg))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if id, ok := strings.CutPrefix(line, "Change-Id:"); ok {
+			changeID = strings.TrimSpace(id)
+		}
+	}
+	return changeID, nil
+}
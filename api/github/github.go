@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/fioncat/gitzombie/api"
 	"github.com/fioncat/gitzombie/config"
 	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
 	"github.com/google/go-github/v48/github"
 	"golang.org/x/oauth2"
 )
@@ -36,17 +38,26 @@ type Provider struct {
 	remote *core.Remote
 }
 
+// The host of public Github, other hosts are treated as Github Enterprise
+// Server.
+const publicHost = "github.com"
+
 func New(remote *core.Remote) (api.Provider, error) {
-	var httpCli *http.Client
-	ctx := context.Background()
+	ctx := api.Context()
+	httpCli := api.NewHTTPClient(remote)
 	if remote.Token != "" {
 		ts := oauth2.StaticTokenSource(&oauth2.Token{
 			AccessToken: remote.Token,
 		})
-		httpCli = oauth2.NewClient(ctx, ts)
+		// The oauth2 client uses the http client in context as base.
+		oauthCtx := context.WithValue(ctx, oauth2.HTTPClient, httpCli)
+		httpCli = oauth2.NewClient(oauthCtx, ts)
 	}
 
-	cli := github.NewClient(httpCli)
+	cli, err := newClient(remote, httpCli)
+	if err != nil {
+		return nil, err
+	}
 	return &Provider{
 		cli:    cli,
 		ctx:    ctx,
@@ -54,6 +65,32 @@ func New(remote *core.Remote) (api.Provider, error) {
 	}, nil
 }
 
+func newClient(remote *core.Remote, httpCli *http.Client) (*github.Client, error) {
+	if remote.API == "" && (remote.Host == "" || remote.Host == publicHost) {
+		return github.NewClient(httpCli), nil
+	}
+
+	baseURL := remote.API
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s/api/v3/", remote.Host)
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api url %q: %v", baseURL, err)
+	}
+	// The upload endpoint of Github Enterprise Server is "/api/uploads/"
+	// under the same host as api, which will be appended by go-github.
+	uploadPath := strings.TrimSuffix(u.Path, "/")
+	uploadPath = strings.TrimSuffix(uploadPath, "/api/v3")
+	uploadURL := fmt.Sprintf("%s://%s%s/", u.Scheme, u.Host, uploadPath)
+
+	cli, err := github.NewEnterpriseClient(baseURL, uploadURL, httpCli)
+	if err != nil {
+		return nil, errors.Trace(err, "create enterprise client")
+	}
+	return cli, nil
+}
+
 func (p *Provider) Name() string { return "Github" }
 
 func (p *Provider) SearchRepositories(group, query string) ([]*api.Repository, error) {
@@ -172,7 +209,7 @@ func (p *Provider) GetMerge(repo *core.Repository, opts api.MergeOption) (string
 			return "", nil
 		}
 
-		return result.Issues[0].GetHTMLURL(), nil
+		return p.webURL(result.Issues[0].GetHTMLURL()), nil
 	}
 
 	prs, resp, err := p.cli.PullRequests.List(p.ctx, pullOpts.Owner, pullOpts.Name,
@@ -191,7 +228,7 @@ func (p *Provider) GetMerge(repo *core.Repository, opts api.MergeOption) (string
 		return "", nil
 	}
 	pr := prs[0]
-	return pr.GetHTMLURL(), nil
+	return p.webURL(pr.GetHTMLURL()), nil
 }
 
 func (p *Provider) CreateMerge(repo *core.Repository, opts api.MergeOption) (string, error) {
@@ -209,7 +246,7 @@ func (p *Provider) CreateMerge(repo *core.Repository, opts api.MergeOption) (str
 	if err != nil || pr == nil {
 		return "", err
 	}
-	return pr.GetHTMLURL(), nil
+	return p.webURL(pr.GetHTMLURL()), nil
 }
 
 func (p *Provider) createPullOptions(repo *core.Repository, opts api.MergeOption) (*pullOptions, error) {
@@ -298,7 +335,7 @@ func (p *Provider) convertRelease(githubRelease *github.RepositoryRelease) *api.
 	release := &api.Release{
 		Name:   githubRelease.GetName(),
 		Tag:    githubRelease.GetTagName(),
-		WebURL: githubRelease.GetHTMLURL(),
+		WebURL: p.webURL(githubRelease.GetHTMLURL()),
 	}
 	release.Files = make([]*api.ReleaseFile, len(githubRelease.Assets))
 	for i, asset := range githubRelease.Assets {
@@ -330,7 +367,7 @@ func (p *Provider) convertRepo(githubRepo *github.Repository) *api.Repository {
 		Name:   githubRepo.GetFullName(),
 		Remote: p.remote,
 
-		WebURL: githubRepo.GetHTMLURL(),
+		WebURL: p.webURL(githubRepo.GetHTMLURL()),
 
 		DefaultBranch: githubRepo.GetDefaultBranch(),
 	}
@@ -341,6 +378,21 @@ func (p *Provider) convertRepo(githubRepo *github.Repository) *api.Repository {
 	return repo
 }
 
+// webURL makes sure that the web url returned by api uses the remote host,
+// the Github Enterprise Server might be configured with a different host
+// for api.
+func (p *Provider) webURL(raw string) string {
+	if raw == "" || p.remote.Host == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == p.remote.Host {
+		return raw
+	}
+	u.Host = p.remote.Host
+	return u.String()
+}
+
 func parseOwner(name string) (string, string, error) {
 	tmp := strings.Split(name, "/")
 	if len(tmp) != 2 {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"time"
 
 	"github.com/fioncat/gitzombie/api"
@@ -18,8 +20,9 @@ func init() {
 }
 
 type Provider struct {
-	cli    *gitlab.Client
-	remote *core.Remote
+	cli     *gitlab.Client
+	httpCli *http.Client
+	remote  *core.Remote
 }
 
 func New(remote *core.Remote) (api.Provider, error) {
@@ -27,12 +30,19 @@ func New(remote *core.Remote) (api.Provider, error) {
 	if url == "" {
 		url = fmt.Sprintf("https://%s/api/v4", remote.Host)
 	}
-	cli, err := gitlab.NewClient(remote.Token, gitlab.WithBaseURL(url))
+	httpCli := api.NewHTTPClient(remote)
+	cli, err := gitlab.NewClient(remote.Token,
+		gitlab.WithBaseURL(url),
+		// The retry is handled by our http client.
+		gitlab.WithoutRetries(),
+		gitlab.WithHTTPClient(httpCli),
+		gitlab.WithRequestOptions(gitlab.WithContext(api.Context())),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Provider{cli: cli, remote: remote}, nil
+	return &Provider{cli: cli, httpCli: httpCli, remote: remote}, nil
 }
 
 func (p *Provider) Name() string { return "Gitlab" }
@@ -181,18 +191,124 @@ func (p *Provider) getMergeRequest(repo *core.Repository, opts api.MergeOption)
 }
 
 func (p *Provider) GetRelease(repo *core.Repository, tag string) (*api.Release, error) {
-	// TODO: support release
-	return nil, errors.New("sorry, gitlab now does not support release")
+	if tag == "" {
+		// The releases are sorted by released_at in descending order by
+		// default, so the first one is the latest.
+		releases, resp, err := p.cli.Releases.ListReleases(repo.Name,
+			&gitlab.ListReleasesOptions{
+				ListOptions: gitlab.ListOptions{PerPage: 1},
+			})
+		if err = p.wrapResp("latest release", resp, err); err != nil {
+			return nil, err
+		}
+		if len(releases) == 0 {
+			return nil, p.notFound("latest release")
+		}
+		return p.convertRelease(releases[0]), nil
+	}
+
+	release, resp, err := p.cli.Releases.GetRelease(repo.Name, tag)
+	if err = p.wrapResp("release "+tag, resp, err); err != nil {
+		return nil, err
+	}
+	return p.convertRelease(release), nil
 }
 
 func (p *Provider) ListReleases(repo *core.Repository) ([]*api.Release, error) {
-	// TODO: support release
-	return nil, errors.New("sorry, gitlab now does not support release")
+	gitlabReleases, resp, err := p.cli.Releases.ListReleases(repo.Name,
+		&gitlab.ListReleasesOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: config.Get().SearchLimit,
+			},
+		})
+	if err = p.wrapResp("releases", resp, err); err != nil {
+		return nil, err
+	}
+	releases := make([]*api.Release, len(gitlabReleases))
+	for i, gitlabRelease := range gitlabReleases {
+		releases[i] = p.convertRelease(gitlabRelease)
+	}
+	return releases, nil
 }
 
 func (p *Provider) DownloadReleaseFile(repo *core.Repository, file *api.ReleaseFile) (io.ReadCloser, error) {
-	// TODO: support release
-	return nil, errors.New("sorry, gitlab now does not support release")
+	link, ok := file.ID.(*gitlab.ReleaseLink)
+	if !ok {
+		return nil, fmt.Errorf("invalid release file %q", file.Name)
+	}
+	downloadURL := link.DirectAssetURL
+	if downloadURL == "" {
+		downloadURL = link.URL
+	}
+
+	req, err := http.NewRequestWithContext(api.Context(), http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, errors.Trace(err, "create download request")
+	}
+	// Only send private token to our GitLab server, the release link might
+	// point to an external site.
+	if p.remote.Token != "" && p.isInternalURL(req.URL) {
+		req.Header.Set("PRIVATE-TOKEN", p.remote.Token)
+	}
+
+	resp, err := p.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, p.notFound(file.Name)
+		}
+		return nil, fmt.Errorf("download %q: bad status %d", file.Name, resp.StatusCode)
+	}
+	// The release link does not have size, use the response length to
+	// show download progress.
+	if resp.ContentLength > 0 {
+		file.Size = resp.ContentLength
+	}
+	return resp.Body, nil
+}
+
+func (p *Provider) isInternalURL(u *url.URL) bool {
+	if u.Host == p.remote.Host {
+		return true
+	}
+	return u.Host == p.cli.BaseURL().Host
+}
+
+func (p *Provider) convertRelease(gitlabRelease *gitlab.Release) *api.Release {
+	release := &api.Release{
+		Name:   gitlabRelease.Name,
+		Tag:    gitlabRelease.TagName,
+		WebURL: gitlabRelease.Links.Self,
+	}
+	if release.Name == "" {
+		release.Name = release.Tag
+	}
+	links := gitlabRelease.Assets.Links
+	release.Files = make([]*api.ReleaseFile, len(links))
+	for i, link := range links {
+		release.Files[i] = &api.ReleaseFile{
+			ID:   link,
+			Name: releaseFileName(link),
+		}
+	}
+	return release
+}
+
+// releaseFileName returns the file name of the release link. The link name
+// is a free text label, so we prefer to use the base of the link url as file
+// name, which is the real file name for generic packages and uploads.
+func releaseFileName(link *gitlab.ReleaseLink) string {
+	u, err := url.Parse(link.URL)
+	if err == nil {
+		name := path.Base(u.Path)
+		if name != "" && name != "/" && name != "." {
+			return name
+		}
+	}
+	return link.Name
 }
 
 func (p *Provider) wrapResp(name string, resp *gitlab.Response, err error) error {
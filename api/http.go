@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/core"
+)
+
+const (
+	defaultTimeout = time.Second * 30
+
+	maxRetry = 3
+
+	// If the rate limit resets within this duration, we wait for it,
+	// otherwise, fail directly.
+	maxRateLimitWait = time.Minute
+
+	minBackoff = time.Second
+	maxBackoff = time.Second * 30
+)
+
+var (
+	ctx     context.Context
+	ctxOnce sync.Once
+)
+
+// Context returns the context for API requests, it will be canceled when
+// receiving interrupt signal. The second signal will kill the process as
+// usual.
+func Context() context.Context {
+	ctxOnce.Do(func() {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	})
+	return ctx
+}
+
+// NewHTTPClient creates http client for calling remote API. The client will
+// retry when the server is unavailable or the rate limit is exceeded, and
+// uses remote timeout for waiting response.
+func NewHTTPClient(remote *core.Remote) *http.Client {
+	timeout := defaultTimeout
+	if remote.Timeout > 0 {
+		timeout = time.Duration(remote.Timeout) * time.Second
+	}
+	return &http.Client{
+		Transport: &retryTransport{
+			base:    http.DefaultTransport,
+			timeout: timeout,
+		},
+	}
+}
+
+type RateLimitError struct {
+	Host  string
+	Reset time.Time
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, resets at %s",
+		err.Host, err.Reset.Local().Format("15:04"))
+}
+
+type retryTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req, attempt)
+		wait, retry, err := t.check(req, resp, err, attempt)
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *retryTransport) roundTrip(req *http.Request, attempt int) (*http.Response, error) {
+	attemptCtx, cancel := context.WithCancel(req.Context())
+	attemptReq := req.WithContext(attemptCtx)
+	if attempt > 0 && req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		attemptReq.Body = body
+	}
+
+	// The timeout only limits waiting for the response header, reading body
+	// (like downloading release file) might take a long time.
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.base.RoundTrip(attemptReq)
+	timeout := !timer.Stop()
+	if err != nil {
+		cancel()
+		if timeout {
+			return nil, fmt.Errorf("request timeout after %v", t.timeout)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *retryTransport) check(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool, error) {
+	// The request body cannot be sent again.
+	canRetry := attempt < maxRetry && (req.Body == nil || req.GetBody != nil)
+	if err != nil {
+		if req.Context().Err() != nil || !canRetry || !idempotent(req) {
+			return 0, false, err
+		}
+		return backoff(attempt), true, nil
+	}
+
+	if reset, ok := rateLimitReset(resp); ok {
+		wait := time.Until(reset)
+		if wait <= 0 {
+			wait = backoff(attempt)
+			reset = time.Now().Add(wait)
+		}
+		if wait > maxRateLimitWait || !canRetry {
+			resp.Body.Close()
+			return 0, false, &RateLimitError{
+				Host:  req.URL.Host,
+				Reset: reset,
+			}
+		}
+		return wait, true, nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return backoff(attempt), canRetry && idempotent(req), nil
+	}
+	return 0, false, nil
+}
+
+// idempotent reports whether the request can be sent again after a network
+// error or a gateway error, the server might have handled it. Like net/http,
+// the non-idempotent request (such as creating merge request) is retried
+// only if the caller sets the "Idempotency-Key" header. The rate limited
+// request is always rejected by server, so it is not checked.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// rateLimitReset returns the reset time if the response indicates that the
+// rate limit is exceeded. Github uses 403 (or 429) with "X-RateLimit-*" or
+// "Retry-After" headers for both primary and secondary rate limits; Gitlab
+// uses 429 with "RateLimit-*" headers.
+func rateLimitReset(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusForbidden {
+		return time.Time{}, false
+	}
+	header := resp.Header
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		seconds, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			return time.Now().Add(time.Duration(seconds) * time.Second), true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return date, true
+		}
+	}
+
+	remaining := header.Get("X-RateLimit-Remaining")
+	reset := header.Get("X-RateLimit-Reset")
+	if remaining == "" {
+		remaining = header.Get("RateLimit-Remaining")
+		reset = header.Get("RateLimit-Reset")
+	}
+	if resp.StatusCode == http.StatusForbidden && remaining != "0" {
+		// Normal permission denied.
+		return time.Time{}, false
+	}
+	if unix, err := strconv.ParseInt(reset, 10, 64); err == nil {
+		return time.Unix(unix, 0), true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return time.Time{}, true
+	}
+	return time.Time{}, false
+}
+
+func backoff(attempt int) time.Duration {
+	wait := minBackoff << attempt
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
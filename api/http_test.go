@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fioncat/gitzombie/core"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	cli := NewHTTPClient(&core.Remote{})
+	resp, err := cli.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if count != 2 {
+		t.Fatalf("expect 2 requests, found %d", count)
+	}
+
+	// The server might have handled the POST, don't send it again.
+	count = 0
+	resp, err = cli.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || count != 1 {
+		t.Fatalf("expect POST not retried, found status %d, %d requests", resp.StatusCode, count)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset.Unix()))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	cli := NewHTTPClient(&core.Remote{})
+	_, err := cli.Get(server.URL)
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("expect rate limit error, found %v", err)
+	}
+	if rateErr.Reset.Unix() != reset.Unix() {
+		t.Fatalf("unexpected reset time %v", rateErr.Reset)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 10):
+		}
+	}))
+	defer server.Close()
+
+	cli := NewHTTPClient(&core.Remote{Timeout: 1})
+	cli.Transport.(*retryTransport).timeout = time.Millisecond * 100
+	req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Do(req)
+	if err == nil {
+		t.Fatal("expect timeout error")
+	}
+}
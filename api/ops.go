@@ -2,7 +2,6 @@ package api
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"sync"
 
@@ -36,22 +35,12 @@ func GetProvider(remote *core.Remote) (Provider, error) {
 		return nil, fmt.Errorf("unknown provider %s", remote.Provider)
 	}
 
-	if remote.TokenSecret {
-		key := remote.Token
-		if key == "" {
-			key = fmt.Sprintf("%s_token", remote.Name)
-		}
-
-		token, err := core.GetSecret(key, true)
-		if err != nil {
-			return nil, errors.Trace(err, "get secret token")
-		}
-		remote.Token = token
-	} else {
-		remote.Token = os.ExpandEnv(remote.Token)
+	token, err := resolveToken(remote)
+	if err != nil {
+		return nil, err
 	}
+	remote.Token = token
 
-	var err error
 	p, err = creator(remote)
 	if err != nil {
 		return nil, err
@@ -66,6 +55,9 @@ func Exec(op string, remote *core.Remote, h func(p Provider) error) error {
 		return err
 	}
 
+	if err = Context().Err(); err != nil {
+		return err
+	}
 	term.PrintOperation("calling %s API to %s", p.Name(), op)
 	err = h(p)
 	return errors.Trace(err, "request %s api", p.Name())
@@ -86,12 +78,7 @@ func SearchRepo(remote *core.Remote, query string) (*Repository, error) {
 		group, query = core.SplitGroup(query)
 	}
 
-	var repos []*Repository
-	var err error
-	err = Exec(op, remote, func(p Provider) error {
-		repos, err = p.SearchRepositories(group, query)
-		return err
-	})
+	repos, err := searchRepos(op, remote, group, query)
 	if err != nil {
 		return nil, err
 	}
@@ -33,6 +33,9 @@ type MergeOption struct {
 	TargetBranch string
 
 	Upstream *Repository
+
+	Topic     string
+	Reviewers []string
 }
 
 type Release struct {
@@ -65,3 +68,10 @@ type Provider interface {
 	ListReleases(repo *core.Repository) ([]*Release, error)
 	DownloadReleaseFile(repo *core.Repository, file *ReleaseFile) (io.ReadCloser, error)
 }
+
+// CommitMerger is implemented by providers that create merge by pushing
+// commits directly, such as Gerrit. The title and body of the merge come
+// from commit message, so there is no need to edit them.
+type CommitMerger interface {
+	CommitMerge()
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+// The resolved tokens, the command and credential helper might ask user for
+// password (such as gpg pinentry), so we only run them once in a process.
+// Guarded by providerLock.
+var tokenCache = map[string]string{}
+
+// GetToken resolves the token of remote without creating provider, the
+// result of command and credential helper is cached in process.
+func GetToken(remote *core.Remote) (string, error) {
+	providerLock.Lock()
+	defer providerLock.Unlock()
+	return resolveToken(remote)
+}
+
+// TokenSecretKey returns the secret key of remote token when token_secret
+// is enabled.
+func TokenSecretKey(remote *core.Remote) string {
+	if remote.Token != "" {
+		return remote.Token
+	}
+	return fmt.Sprintf("%s_token", remote.Name)
+}
+
+func resolveToken(remote *core.Remote) (string, error) {
+	switch {
+	case remote.TokenSecret:
+		token, err := core.GetSecret(TokenSecretKey(remote), true)
+		return token, errors.Trace(err, "get secret token")
+
+	case remote.TokenCmd != "":
+		return cacheToken("cmd:"+remote.TokenCmd, func() (string, error) {
+			return tokenFromCmd(remote.TokenCmd)
+		})
+
+	case remote.TokenCredential:
+		return cacheToken("credential:"+remote.Host, func() (string, error) {
+			return tokenFromCredential(remote)
+		})
+	}
+	return os.ExpandEnv(remote.Token), nil
+}
+
+func cacheToken(key string, get func() (string, error)) (string, error) {
+	if token, ok := tokenCache[key]; ok {
+		return token, nil
+	}
+	token, err := get()
+	if err != nil {
+		return "", err
+	}
+	tokenCache[key] = token
+	return token, nil
+}
+
+// tokenFromCmd uses the first line of the command's stdout as token, this is
+// compatible with password managers like "pass".
+func tokenFromCmd(cmdStr string) (string, error) {
+	cmd := exec.Command("bash", "-c", cmdStr)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("run token command %q: %v", cmdStr, err)
+	}
+	token, _, _ := strings.Cut(out.String(), "\n")
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("token command %q output is empty", cmdStr)
+	}
+	return token, nil
+}
+
+// tokenFromCredential asks git credential helpers for the password of the
+// remote host.
+func tokenFromCredential(remote *core.Remote) (string, error) {
+	input := fmt.Sprintf("protocol=https\nhost=%s\n", remote.Host)
+	if remote.User != "" {
+		input += fmt.Sprintf("username=%s\n", remote.User)
+	}
+	input += "\n"
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	// Do not let git prompt for username and password in terminal when the
+	// credential is missing.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return "", fmt.Errorf("git credential fill for %s: %s", remote.Host, msg)
+		}
+		return "", fmt.Errorf("git credential fill for %s: %v", remote.Host, err)
+	}
+
+	var username, password string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	if password == "" {
+		return "", fmt.Errorf("no password from git credential for %s", remote.Host)
+	}
+
+	switch remote.Provider {
+	case "bitbucket", "gerrit":
+		// These providers use basic auth, the username in credential might
+		// be different from the remote user.
+		if username != "" {
+			return username + ":" + password, nil
+		}
+	}
+	return password, nil
+}
@@ -72,6 +72,10 @@ func Register[Flags, Data any](app *Command[Flags, Data]) *cobra.Command {
 	if app.PrepareNoFlag != nil {
 		app.PrepareNoFlag(cmd)
 	}
+	if app.Run == nil && app.RunNoContext == nil {
+		// The command only groups sub commands.
+		return addAction(app.Action, cmd)
+	}
 	cmd.RunE = func(_ *cobra.Command, args []string) error {
 		ctx := &Context[Flags, Data]{
 			args:  args,
@@ -103,19 +107,32 @@ func Register[Flags, Data any](app *Command[Flags, Data]) *cobra.Command {
 		}
 		return nil
 	}
-	if app.Action == "" {
-		name := strings.Split(app.Use, " ")[0]
+	return addAction(app.Action, cmd)
+}
+
+func addAction(action string, cmd *cobra.Command) *cobra.Command {
+	if action == "" {
+		name := strings.Split(cmd.Use, " ")[0]
+		if group := actions[name]; group != nil {
+			// The sub commands are registered before the command.
+			for _, sub := range group.Commands() {
+				group.RemoveCommand(sub)
+				cmd.AddCommand(sub)
+			}
+		}
 		actions[name] = cmd
 		return cmd
 	}
 
-	actionCmd := actions[app.Action]
+	// The action can be an existing command, such as "run history".
+	key := strings.ToLower(action)
+	actionCmd := actions[key]
 	if actionCmd == nil {
 		actionCmd = &cobra.Command{
-			Use:   strings.ToLower(app.Action),
-			Short: fmt.Sprintf("%s actions", app.Action),
+			Use:   key,
+			Short: fmt.Sprintf("%s actions", action),
 		}
-		actions[app.Action] = actionCmd
+		actions[key] = actionCmd
 	}
 	actionCmd.AddCommand(cmd)
 	return cmd
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"github.com/fioncat/gitzombie/api"
 	"github.com/fioncat/gitzombie/config"
 	"github.com/fioncat/gitzombie/core"
 	"github.com/fioncat/gitzombie/pkg/git"
@@ -89,7 +90,7 @@ func CompGitTag(_ []string) (*CompResult, error) {
 	return &CompResult{Items: items}, nil
 }
 
-func compListRepos(args []string) ([]*core.Repository, error) {
+func compListRepos(args []string) ([]string, error) {
 	remote := args[0]
 	if remote == "" {
 		return nil, nil
@@ -98,7 +99,23 @@ func compListRepos(args []string) ([]*core.Repository, error) {
 	if err != nil {
 		return nil, err
 	}
-	return store.List(remote), nil
+	repos := store.List(remote)
+	names := make([]string, 0, len(repos))
+	set := make(map[string]struct{}, len(repos))
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+		set[repo.Name] = struct{}{}
+	}
+
+	// Offer the repos that are not cloned yet from cache, this won't call
+	// API.
+	for _, name := range api.ListCachedRepoNames(remote) {
+		if _, ok := set[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
 }
 
 func CompRemote(_ []string) (*CompResult, error) {
@@ -122,31 +139,31 @@ func CompWorkflow(_ []string) (*CompResult, error) {
 }
 
 func CompRepo(args []string) (*CompResult, error) {
-	repos, err := compListRepos(args)
+	names, err := compListRepos(args)
 	if err != nil {
 		return nil, err
 	}
 
-	items := make([]string, len(repos))
-	for i, repo := range repos {
-		items[i] = repo.Name
-	}
-
-	return &CompResult{Items: items}, nil
+	return &CompResult{Items: names}, nil
 }
 
 func CompGroup(args []string) (*CompResult, error) {
-	repos, err := compListRepos(args)
+	names, err := compListRepos(args)
 	if err != nil {
 		return nil, err
 	}
 
 	return &CompResult{
-		Items: core.ConvertToGroups(repos),
+		Items: core.ConvertNamesToGroups(names),
 		Flag:  CompNoSpaceFlag,
 	}, nil
 }
 
+func CompSecret(_ []string) (*CompResult, error) {
+	keys, err := core.ListSecretKeys()
+	return &CompResult{Items: keys}, err
+}
+
 func CompTemplate(_ []string) (*CompResult, error) {
 	names, err := core.ListTemplates()
 	if err != nil {
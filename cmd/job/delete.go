@@ -2,7 +2,7 @@ package job
 
 import (
 	"github.com/fioncat/gitzombie/cmd/app"
-	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
 	"github.com/spf13/cobra"
 )
 
@@ -17,8 +17,10 @@ var Job = app.Register(&app.Command[app.Empty, app.Empty]{
 	},
 
 	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
-		name := ctx.Arg(0)
-		path := config.GetDir("jobs", name+".sh")
+		path, err := core.GetJobPath(ctx.Arg(0))
+		if err != nil {
+			return err
+		}
 		return app.Delete(ctx.Arg(0), path)
 	},
 })
@@ -0,0 +1,51 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+var Describe = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "describe {job}",
+	Desc:   "Show job description and params",
+	Action: "Job",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.ExactArgs(1)
+		cmd.ValidArgsFunction = app.Comp(app.CompJob)
+	},
+
+	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
+		jobFile, err := core.GetJobFile(ctx.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		term.Printf("Name: %s", term.Style(jobFile.Name, "green"))
+		term.Printf("Path: %s", term.Style(jobFile.Path, "green"))
+		if jobFile.Description != "" {
+			term.Printf("Desc: %s", jobFile.Description)
+		}
+
+		if len(jobFile.Params) == 0 {
+			return nil
+		}
+		term.Println()
+		term.Println("Params:")
+		for _, param := range jobFile.Params {
+			attr := term.Style("required", "yellow")
+			if !param.Required {
+				attr = fmt.Sprintf("default %q", param.Default)
+			}
+			term.Printf("* %s (%s, env %s)", term.Style(param.Name, "green"), attr, param.EnvName())
+			if param.Description != "" {
+				term.Printf("  %s", param.Description)
+			}
+		}
+		return nil
+	},
+})
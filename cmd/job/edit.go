@@ -2,9 +2,12 @@ package job
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/fioncat/gitzombie/cmd/app"
 	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/core"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +22,28 @@ var Edit = app.Register(&app.Command[app.Empty, app.Empty]{
 	},
 
 	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
-		name := fmt.Sprintf("%s.sh", ctx.Arg(0))
-		path := config.GetDir("jobs", name)
-		return app.Edit(path, "", name, nil)
+		name := ctx.Arg(0)
+		path, err := core.GetJobPath(name)
+		if err != nil {
+			// Create a new job, the extension can be specified by name,
+			// such as "lint.py". Default is shell script.
+			if filepath.Ext(name) == "" {
+				name = fmt.Sprintf("%s.sh", name)
+			}
+			path = config.GetDir("jobs", name)
+		}
+		err = app.Edit(path, "", filepath.Base(path), nil)
+		if err != nil {
+			return err
+		}
+		if core.JobFileShell(path) != "" {
+			return nil
+		}
+		// The job file without known extension is executed directly.
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode()|0111)
 	},
 })
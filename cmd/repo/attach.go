@@ -10,7 +10,7 @@ import (
 )
 
 var Attach = app.Register(&app.Command[app.Empty, core.RepositoryStorage]{
-	Use:  "attach {remote} {repo}",
+	Use:  "attach [--refresh] {remote} {repo}",
 	Desc: "Attach current path to a repo",
 
 	Init: initData[app.Empty],
@@ -18,6 +18,7 @@ var Attach = app.Register(&app.Command[app.Empty, core.RepositoryStorage]{
 	PrepareNoFlag: func(cmd *cobra.Command) {
 		cmd.Args = cobra.ExactArgs(2)
 		cmd.ValidArgsFunction = app.Comp(app.CompRemote, app.CompGroup)
+		cmd.Flags().BoolVarP(&api.RefreshCache, "refresh", "", false, "refresh repo cache")
 	},
 
 	Run: func(ctx *app.Context[app.Empty, core.RepositoryStorage]) error {
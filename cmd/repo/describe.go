@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"strings"
 	"time"
 
 	"github.com/fioncat/gitzombie/cmd/app"
@@ -50,6 +51,9 @@ var Describe = app.Register(&app.Command[app.Empty, core.RepositoryStorage]{
 		term.Printf("Group:  %s", term.Style(repo.Group(), "green"))
 		term.Printf("Base:   %s", term.Style(repo.Base(), "green"))
 		term.Printf("Remote: %s", term.Style(repo.Remote, "green"))
+		if len(repo.Labels) > 0 {
+			term.Printf("Labels: %s", term.Style(strings.Join(repo.Labels, ", "), "green"))
+		}
 
 		term.Println()
 		term.Println("Access:")
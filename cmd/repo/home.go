@@ -18,7 +18,7 @@ type HomeFlags struct {
 }
 
 var Home = app.Register(&app.Command[HomeFlags, core.RepositoryStorage]{
-	Use:  "home {remote} {repo}",
+	Use:  "home [-s] [--refresh] {remote} {repo}",
 	Desc: "Enter or clone a repo",
 
 	Init: initData[HomeFlags],
@@ -27,6 +27,7 @@ var Home = app.Register(&app.Command[HomeFlags, core.RepositoryStorage]{
 		cmd.Args = cobra.RangeArgs(1, 2)
 		cmd.ValidArgsFunction = app.Comp(app.CompRemote, app.CompRepo)
 		cmd.Flags().BoolVarP(&flags.Search, "search", "s", false, "search from remote")
+		cmd.Flags().BoolVarP(&api.RefreshCache, "refresh", "", false, "refresh repo cache")
 	},
 
 	Run: func(ctx *app.Context[HomeFlags, core.RepositoryStorage]) error {
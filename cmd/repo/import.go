@@ -23,7 +23,7 @@ type ImportFlags struct {
 }
 
 var Import = app.Register(&app.Command[ImportFlags, core.RepositoryStorage]{
-	Use:  "import [-i ignore-repo]... {remote} {group}",
+	Use:  "import [-i ignore-repo]... [--refresh] {remote} {group}",
 	Desc: "Import repos to workspace",
 
 	Init: initData[ImportFlags],
@@ -31,6 +31,7 @@ var Import = app.Register(&app.Command[ImportFlags, core.RepositoryStorage]{
 	Prepare: func(cmd *cobra.Command, flags *ImportFlags) {
 		cmd.Flags().StringSliceVarP(&flags.Ignore, "ignore", "i", nil, "ignore repo pattern")
 		cmd.Flags().StringVarP(&flags.LogPath, "log-path", "", "", "log path")
+		cmd.Flags().BoolVarP(&api.RefreshCache, "refresh", "", false, "refresh repo cache")
 
 		cmd.Args = cobra.ExactArgs(2)
 		cmd.ValidArgsFunction = app.Comp(app.CompRemote, app.CompGroup)
@@ -45,11 +46,7 @@ var Import = app.Register(&app.Command[ImportFlags, core.RepositoryStorage]{
 			return err
 		}
 
-		var apiRepos []*api.Repository
-		err = api.Exec("list repos", remote, func(p api.Provider) error {
-			apiRepos, err = p.ListRepositories(group)
-			return err
-		})
+		apiRepos, err := api.ListRepos(remote, group)
 		if err != nil {
 			return err
 		}
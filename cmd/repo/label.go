@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/spf13/cobra"
+)
+
+type LabelFlags struct {
+	Delete bool
+}
+
+var Label = app.Register(&app.Command[LabelFlags, core.RepositoryStorage]{
+	Use:  "label [-d] [label]...",
+	Desc: "Add or delete labels of current repo, print labels if no arg",
+
+	Init: initData[LabelFlags],
+
+	Prepare: func(cmd *cobra.Command, flags *LabelFlags) {
+		cmd.Flags().BoolVarP(&flags.Delete, "delete", "d", false, "delete labels")
+	},
+
+	Run: func(ctx *app.Context[LabelFlags, core.RepositoryStorage]) error {
+		repo, err := ctx.Data.GetCurrent()
+		if err != nil {
+			return err
+		}
+		if ctx.ArgLen() == 0 {
+			ctx.Data.ReadOnly()
+			for _, label := range repo.Labels {
+				fmt.Println(label)
+			}
+			return nil
+		}
+		labels := make([]string, ctx.ArgLen())
+		for i := range labels {
+			labels[i] = ctx.Arg(i)
+		}
+		repo.SetLabels(labels, ctx.Flags.Delete)
+		return nil
+	},
+})
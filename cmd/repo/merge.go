@@ -22,10 +22,13 @@ type MergeFlags struct {
 
 	TargetBranch string
 	SourceBranch string
+
+	Topic     string
+	Reviewers []string
 }
 
 var Merge = app.Register(&app.Command[MergeFlags, core.RepositoryStorage]{
-	Use:  "merge [-u] [-s source-branch] [-t target-branch]",
+	Use:  "merge [-u] [-s source-branch] [-t target-branch] [--topic topic] [-r reviewer]...",
 	Desc: "Open or create PullRequest or MergeRequest",
 
 	Init: initData[MergeFlags],
@@ -38,6 +41,9 @@ var Merge = app.Register(&app.Command[MergeFlags, core.RepositoryStorage]{
 		cmd.RegisterFlagCompletionFunc("source", app.Comp(app.CompGitLocalBranch(false)))
 		cmd.RegisterFlagCompletionFunc("target", app.Comp(app.CompGitLocalBranch(false)))
 
+		cmd.Flags().StringVarP(&flags.Topic, "topic", "", "", "merge topic, only for gerrit")
+		cmd.Flags().StringSliceVarP(&flags.Reviewers, "reviewer", "r", nil, "merge reviewers, only for gerrit")
+
 		cmd.Args = cobra.ExactArgs(0)
 	},
 
@@ -81,12 +87,18 @@ var Merge = app.Register(&app.Command[MergeFlags, core.RepositoryStorage]{
 		}
 
 		term.ConfirmExit("cannot find merge, do you want to create one")
-		title, body, err := mergeEdit()
+		p, err := api.GetProvider(remote)
 		if err != nil {
 			return err
 		}
-		opts.Title = title
-		opts.Body = body
+		if _, ok := p.(api.CommitMerger); !ok {
+			title, body, err := mergeEdit()
+			if err != nil {
+				return err
+			}
+			opts.Title = title
+			opts.Body = body
+		}
 
 		term.Println()
 		term.Println("About to create merge:")
@@ -130,10 +142,18 @@ func mergeBuildOptions(ctx *app.Context[MergeFlags, core.RepositoryStorage], api
 		up = apiRepo.Upstream
 	}
 
+	topic := ctx.Flags.Topic
+	if topic == "" {
+		topic = src
+	}
+
 	return &api.MergeOption{
 		SourceBranch: src,
 		TargetBranch: tar,
 		Upstream:     up,
+
+		Topic:     topic,
+		Reviewers: ctx.Flags.Reviewers,
 	}, nil
 }
 
@@ -215,8 +235,10 @@ func mergeShowInfo(repo *core.Repository, opts *api.MergeOption) {
 		src = fmt.Sprintf("%s:%s", srcRepo, srcBranch)
 		tar = fmt.Sprintf("%s:%s", tarRepo, tarBranch)
 	}
-	term.Printf(" * Title:  %s", term.Style(opts.Title, "green"))
-	term.Printf(" * Body:   %s", lineDesc)
+	if opts.Title != "" {
+		term.Printf(" * Title:  %s", term.Style(opts.Title, "green"))
+		term.Printf(" * Body:   %s", lineDesc)
+	}
 	term.Printf(" * Source: %s", src)
 	term.Printf(" * Target: %s", tar)
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/fioncat/gitzombie/scripts"
 	"github.com/spf13/cobra"
 
+	_ "github.com/fioncat/gitzombie/api/bitbucket"
+	_ "github.com/fioncat/gitzombie/api/gerrit"
 	_ "github.com/fioncat/gitzombie/api/github"
 	_ "github.com/fioncat/gitzombie/api/gitlab"
 
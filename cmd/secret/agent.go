@@ -0,0 +1,89 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+type AgentFlags struct {
+	Timeout    time.Duration
+	Foreground bool
+}
+
+var Agent = app.Register(&app.Command[AgentFlags, app.Empty]{
+	Use:    "agent [-t timeout] [--foreground]",
+	Desc:   "Start agent to cache decrypted secrets",
+	Action: "Secret",
+
+	Prepare: func(cmd *cobra.Command, flags *AgentFlags) {
+		cmd.Flags().DurationVarP(&flags.Timeout, "timeout", "t", time.Minute*15, "wipe secret after it is idle for timeout")
+		cmd.Flags().BoolVarP(&flags.Foreground, "foreground", "", false, "run agent in foreground")
+		cmd.Args = cobra.NoArgs
+	},
+
+	Run: func(ctx *app.Context[AgentFlags, app.Empty]) error {
+		if ctx.Flags.Timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		if ctx.Flags.Foreground {
+			return core.RunSecretAgent(ctx.Flags.Timeout)
+		}
+		if core.AgentRunning() {
+			return errors.New("secret agent is already running")
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return errors.Trace(err, "get executable")
+		}
+		cmd := exec.Command(exe, "secret", "agent", "--foreground",
+			"--timeout", ctx.Flags.Timeout.String())
+		// Detach from current session, so that the agent won't be killed when
+		// the terminal is closed.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		err = cmd.Start()
+		if err != nil {
+			return errors.Trace(err, "start agent")
+		}
+
+		for i := 0; i < 20; i++ {
+			if core.AgentRunning() {
+				fmt.Printf("Secret agent started, pid %d\n", cmd.Process.Pid)
+				return cmd.Process.Release()
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		cmd.Process.Kill()
+		return errors.New("secret agent does not start in time")
+	},
+})
+
+var Lock = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "lock",
+	Desc:   "Wipe secrets cached in agent",
+	Action: "Secret",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.NoArgs
+	},
+
+	RunNoContext: func() error {
+		running, err := core.LockSecretAgent()
+		if err != nil {
+			return err
+		}
+		if !running {
+			term.Warn("secret agent is not running")
+		}
+		return nil
+	},
+})
@@ -0,0 +1,61 @@
+package secret
+
+import (
+	"os"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+type ExportFlags struct {
+	Output string
+}
+
+var Export = app.Register(&app.Command[ExportFlags, app.Empty]{
+	Use:    "export [-o output]",
+	Desc:   "Export all secrets to an encrypted bundle",
+	Action: "Secret",
+
+	Prepare: func(cmd *cobra.Command, flags *ExportFlags) {
+		cmd.Flags().StringVarP(&flags.Output, "output", "o", "", "output file")
+		cmd.Args = cobra.NoArgs
+	},
+
+	Run: func(ctx *app.Context[ExportFlags, app.Empty]) error {
+		data, err := core.ExportSecrets()
+		if err != nil {
+			return err
+		}
+		if ctx.Flags.Output != "" {
+			return osutil.WriteFile(ctx.Flags.Output, data)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	},
+})
+
+var Import = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "import {file}",
+	Desc:   "Import secrets from an encrypted bundle",
+	Action: "Secret",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.ExactArgs(1)
+	},
+
+	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
+		data, err := os.ReadFile(ctx.Arg(0))
+		if err != nil {
+			return err
+		}
+		count, err := core.ImportSecrets(data)
+		if err != nil {
+			return err
+		}
+		term.Printf("Imported %d secret(s)", count)
+		return nil
+	},
+})
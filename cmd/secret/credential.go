@@ -0,0 +1,194 @@
+package secret
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fioncat/gitzombie/api"
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/git"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+// The git credential helper protocol, see:
+// https://git-scm.com/docs/git-credential#IOFMT
+var CredentialHelper = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "credential-helper {get|store|erase|install}",
+	Desc:   "Git credential helper that provides remote tokens",
+	Action: "Secret",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.ExactArgs(1)
+		cmd.ValidArgs = []string{"get", "store", "erase", "install"}
+	},
+
+	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
+		op := ctx.Arg(0)
+		if op == "install" {
+			return installCredentialHelper()
+		}
+
+		cred, err := readCredential(os.Stdin)
+		if err != nil {
+			return err
+		}
+		remote, err := matchCredentialRemote(cred)
+		if err != nil || remote == nil {
+			// Let git try other helpers.
+			return err
+		}
+
+		switch op {
+		case "get":
+			return getCredential(remote)
+
+		case "store":
+			return storeCredential(remote, cred)
+
+		case "erase":
+			if remote.TokenSecret {
+				core.ForgetSecret(api.TokenSecretKey(remote))
+			}
+			return nil
+		}
+		// Unknown operations should be ignored by helper.
+		return nil
+	},
+})
+
+func readCredential(r io.Reader) (map[string]string, error) {
+	cred := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		cred[key] = value
+	}
+	return cred, errors.Trace(scanner.Err(), "read credential")
+}
+
+// matchCredentialRemote finds the https remote whose host is the same as the
+// credential. The remote uses git credential to get token is ignored, to
+// avoid calling ourself.
+func matchCredentialRemote(cred map[string]string) (*core.Remote, error) {
+	if cred["protocol"] != "https" {
+		return nil, nil
+	}
+	host := cred["host"]
+	names, err := core.ListRemoteNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		remote, err := core.GetRemote(name)
+		if err != nil {
+			return nil, err
+		}
+		if remote.Host != host || remote.TokenCredential {
+			continue
+		}
+		username := cred["username"]
+		if username != "" && username != remote.User {
+			continue
+		}
+		return remote, nil
+	}
+	return nil, nil
+}
+
+func getCredential(remote *core.Remote) error {
+	// The stdin is used by git, read password from terminal. If there is no
+	// terminal, the token can only come from agent or env.
+	_ = term.UseTTY()
+	token, err := api.GetToken(remote)
+	if err != nil {
+		term.Warn("get token for %s: %v", remote.Name, err)
+		return nil
+	}
+	if token == "" {
+		return nil
+	}
+
+	username, password := remote.User, token
+	// Bitbucket and Gerrit token might be "username:password".
+	if user, pass, ok := strings.Cut(token, ":"); ok {
+		username, password = user, pass
+	}
+	fmt.Printf("username=%s\npassword=%s\n", username, password)
+	return nil
+}
+
+// storeCredential saves the password input by user to secret store if the
+// remote token is not created yet. The existing token is never overwritten.
+func storeCredential(remote *core.Remote, cred map[string]string) error {
+	password := cred["password"]
+	if !remote.TokenSecret || password == "" {
+		return nil
+	}
+	key := api.TokenSecretKey(remote)
+	exists, err := core.SecretExists(key)
+	if err != nil || exists {
+		return err
+	}
+	err = term.UseTTY()
+	if err != nil {
+		return err
+	}
+	return core.StoreSecret(key, password)
+}
+
+// installCredentialHelper registers the helper to global git config for
+// hosts of all remotes.
+func installCredentialHelper() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Trace(err, "get executable")
+	}
+	helper := fmt.Sprintf("!%q secret credential-helper", exe)
+
+	names, err := core.ListRemoteNames()
+	if err != nil {
+		return err
+	}
+	hosts := make(map[string]struct{})
+	for _, name := range names {
+		remote, err := core.GetRemote(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := hosts[remote.Host]; ok {
+			continue
+		}
+		hosts[remote.Host] = struct{}{}
+
+		key := fmt.Sprintf("credential.https://%s.helper", remote.Host)
+		helpers, _ := git.OutputItems([]string{"config", "--global", "--get-all", key}, git.Mute)
+		var installed bool
+		for _, h := range helpers {
+			if h == helper {
+				installed = true
+				break
+			}
+		}
+		if installed {
+			continue
+		}
+		err = git.Exec([]string{"config", "--global", "--add", key, helper}, git.Default)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ var Describe = app.Register(&app.Command[app.Empty, app.Empty]{
 
 	PrepareNoFlag: func(cmd *cobra.Command) {
 		cmd.Args = cobra.ExactArgs(1)
+		cmd.ValidArgsFunction = app.Comp(app.CompSecret)
 	},
 
 	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
@@ -2,11 +2,13 @@ package secret
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"strings"
 
 	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
 	"github.com/fioncat/gitzombie/pkg/crypto"
+	"github.com/fioncat/gitzombie/pkg/errors"
 	"github.com/fioncat/gitzombie/pkg/osutil"
 	"github.com/fioncat/gitzombie/pkg/term"
 	"github.com/spf13/cobra"
@@ -15,6 +17,9 @@ import (
 type FileFlags struct {
 	Write  bool
 	Output string
+
+	Recipients []string
+	Identities []string
 }
 
 func prepareFileCmd(cmd *cobra.Command, flags *FileFlags) {
@@ -24,81 +29,155 @@ func prepareFileCmd(cmd *cobra.Command, flags *FileFlags) {
 	cmd.Args = cobra.ExactArgs(1)
 }
 
-var Encrypt = app.Register(&app.Command[FileFlags, app.Empty]{
-	Use:  "encrypt [-w] [-o output] {file}",
-	Desc: "Encrypt a file use given password",
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeOutput(flags *FileFlags, inPath string, data []byte) error {
+	var outPath string
+	if flags.Write && inPath != "-" {
+		outPath = inPath
+	} else {
+		outPath = flags.Output
+	}
+	if outPath != "" {
+		return osutil.WriteFile(outPath, data)
+	}
+
+	fmt.Print(string(data))
+	return nil
+}
+
+// The encrypt and decrypt commands are available both as "gz encrypt" and
+// "gz secret encrypt".
+func registerFileCmd(cmd *app.Command[FileFlags, app.Empty]) *cobra.Command {
+	secretCmd := *cmd
+	secretCmd.Action = "Secret"
+	app.Register(&secretCmd)
+	return app.Register(cmd)
+}
+
+var Encrypt = registerFileCmd(&app.Command[FileFlags, app.Empty]{
+	Use:  "encrypt [-w] [-o output] [-r recipient]... {file}",
+	Desc: "Encrypt a file use given password or recipients",
 
-	Prepare: prepareFileCmd,
+	Prepare: func(cmd *cobra.Command, flags *FileFlags) {
+		prepareFileCmd(cmd, flags)
+		cmd.Flags().StringArrayVarP(&flags.Recipients, "recipient", "r", nil, "encrypt to the public key (file), can be repeated")
+	},
 
 	Run: func(ctx *app.Context[FileFlags, app.Empty]) error {
 		inPath := ctx.Arg(0)
 
-		data, err := os.ReadFile(inPath)
+		data, err := readInput(inPath)
 		if err != nil {
 			return err
 		}
 
+		if len(ctx.Flags.Recipients) > 0 {
+			recipients, err := core.ReadRecipients(ctx.Flags.Recipients)
+			if err != nil {
+				return err
+			}
+			encrypted, err := crypto.EncryptRecipients(data, recipients)
+			if err != nil {
+				return err
+			}
+			return writeOutput(ctx.Flags, inPath, encrypted)
+		}
+
 		password, err := term.InputNewPassword("Please input new password")
 		if err != nil {
 			return err
 		}
 
-		encrypted, _, err := crypto.Encrypt(password, data, true)
+		encrypted, err := crypto.EncryptPassword(password, data)
 		if err != nil {
 			return err
 		}
-
-		var outPath string
-		if ctx.Flags.Write {
-			outPath = inPath
-		} else {
-			outPath = ctx.Flags.Output
-		}
-		if outPath != "" {
-			return osutil.WriteFile(outPath, []byte(encrypted))
-		}
-
-		fmt.Print(encrypted)
-		return nil
+		return writeOutput(ctx.Flags, inPath, encrypted)
 	},
 })
 
-var Decrypt = app.Register(&app.Command[FileFlags, app.Empty]{
-	Use:  "decrypt [-w] [-o output] {file}",
-	Desc: "Decrypt a file use given password",
+var Decrypt = registerFileCmd(&app.Command[FileFlags, app.Empty]{
+	Use:  "decrypt [-w] [-o output] [-i identity]... {file}",
+	Desc: "Decrypt a file use given password or private key",
 
-	Prepare: prepareFileCmd,
+	Prepare: func(cmd *cobra.Command, flags *FileFlags) {
+		prepareFileCmd(cmd, flags)
+		cmd.Flags().StringArrayVarP(&flags.Identities, "identity", "i", nil, "the private key file, default is the key generated by keygen")
+	},
 
 	Run: func(ctx *app.Context[FileFlags, app.Empty]) error {
 		inPath := ctx.Arg(0)
 
-		data, err := os.ReadFile(inPath)
+		data, err := readInput(inPath)
 		if err != nil {
 			return err
 		}
 
+		if crypto.IsRecipientEncrypted(data) {
+			ids, err := core.ReadIdentities(ctx.Flags.Identities)
+			if err != nil {
+				return err
+			}
+			raw, err := crypto.DecryptIdentities(data, ids)
+			if err != nil {
+				return err
+			}
+			return writeOutput(ctx.Flags, inPath, raw)
+		}
+
 		password, err := term.InputPassword("Please input password")
 		if err != nil {
 			return err
 		}
 
-		value := strings.TrimSpace(string(data))
-		raw, err := crypto.Decrypt(password, "", value)
+		raw, err := crypto.DecryptPassword(password, data)
 		if err != nil {
 			return err
 		}
+		return writeOutput(ctx.Flags, inPath, raw)
+	},
+})
+
+type KeygenFlags struct {
+	Output string
+}
+
+var Keygen = app.Register(&app.Command[KeygenFlags, app.Empty]{
+	Use:    "keygen [-o output]",
+	Desc:   "Generate key pair for recipient encryption",
+	Action: "Secret",
 
-		var outPath string
-		if ctx.Flags.Write {
-			outPath = inPath
-		} else {
-			outPath = ctx.Flags.Output
+	Prepare: func(cmd *cobra.Command, flags *KeygenFlags) {
+		cmd.Flags().StringVarP(&flags.Output, "output", "o", "", "the private key path, the public key is written to {output}.pub")
+		cmd.Args = cobra.NoArgs
+	},
+
+	Run: func(ctx *app.Context[KeygenFlags, app.Empty]) error {
+		path := ctx.Flags.Output
+		if path == "" {
+			path = core.IdentityPath()
+		}
+		exists, err := osutil.FileExists(path)
+		if err != nil {
+			return errors.Trace(err, "check key exists")
 		}
-		if outPath != "" {
-			return osutil.WriteFile(outPath, raw)
+		if exists {
+			term.ConfirmExit("The key %s already exists, do you want to overwrite it", path)
 		}
 
-		fmt.Print(string(raw))
+		recipient, err := core.GenerateIdentity(path)
+		if err != nil {
+			return err
+		}
+		term.Printf("Private key written to %s", path)
+		term.Printf("Public key written to %s.pub, share it with others:", path)
+		fmt.Println(recipient)
 		return nil
 	},
 })
@@ -0,0 +1,92 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+// The clean, smudge and textconv commands are called by git.
+var Filter = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "filter",
+	Desc:   "Git filter to store files encrypted in repo",
+	Action: "Secret",
+})
+
+func addFilterCmd(cmd *cobra.Command) {
+	Filter.AddCommand(cmd)
+}
+
+func init() {
+	addFilterCmd(&cobra.Command{
+		Use:   "install [pattern]...",
+		Short: "Install filter to current repo, protect patterns in .gitattributes",
+
+		RunE: func(_ *cobra.Command, args []string) error {
+			return core.InstallFilter(args)
+		},
+	})
+
+	addFilterCmd(&cobra.Command{
+		Use:   "status",
+		Short: "List the protected files in current repo",
+		Args:  cobra.NoArgs,
+
+		RunE: func(_ *cobra.Command, _ []string) error {
+			files, err := core.ListFilterFiles()
+			if err != nil {
+				return err
+			}
+			var plain int
+			for _, file := range files {
+				if file.Encrypted {
+					fmt.Printf("%s  %s\n", term.Style("encrypted", "green"), file.Path)
+					continue
+				}
+				plain++
+				fmt.Printf("%s  %s\n", term.Style("plaintext", "red"), file.Path)
+			}
+			if plain > 0 {
+				return fmt.Errorf("%d protected file(s) are staged in plain text, please use \"git add --renormalize\" to encrypt them", plain)
+			}
+			return nil
+		},
+	})
+
+	addFilterCmd(&cobra.Command{
+		Use:    "clean {path}",
+		Short:  "Encrypt file content from stdin, called by git",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+
+		RunE: func(_ *cobra.Command, args []string) error {
+			return core.FilterClean(args[0], os.Stdin, os.Stdout)
+		},
+	})
+
+	addFilterCmd(&cobra.Command{
+		Use:    "smudge {path}",
+		Short:  "Decrypt file content from stdin, called by git",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+
+		RunE: func(_ *cobra.Command, args []string) error {
+			return core.FilterSmudge(args[0], os.Stdin, os.Stdout)
+		},
+	})
+
+	addFilterCmd(&cobra.Command{
+		Use:    "textconv {file}",
+		Short:  "Print decrypted file, called by git diff",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+
+		RunE: func(_ *cobra.Command, args []string) error {
+			return core.FilterTextconv(args[0], os.Stdout)
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package secret
+
+import (
+	"fmt"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/spf13/cobra"
+)
+
+var List = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "secret",
+	Desc:   "List secret keys",
+	Action: "List",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.NoArgs
+	},
+
+	RunNoContext: func() error {
+		keys, err := core.ListSecretKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return nil
+	},
+})
@@ -0,0 +1,31 @@
+package secret
+
+import (
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+var Migrate = app.Register(&app.Command[app.Empty, app.Empty]{
+	Use:    "migrate",
+	Desc:   "Re-encrypt secrets with the latest key derivation",
+	Action: "Secret",
+
+	PrepareNoFlag: func(cmd *cobra.Command) {
+		cmd.Args = cobra.NoArgs
+	},
+
+	RunNoContext: func() error {
+		count, err := core.MigrateSecrets()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			term.Printf("All secrets are up to date")
+			return nil
+		}
+		term.Printf("Migrated %d secret(s)", count)
+		return nil
+	},
+})
@@ -0,0 +1,26 @@
+package secret
+
+import (
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/spf13/cobra"
+)
+
+type RekeyFlags struct {
+	Master bool
+}
+
+var Rekey = app.Register(&app.Command[RekeyFlags, app.Empty]{
+	Use:    "rekey [--master]",
+	Desc:   "Re-encrypt all secrets with a new password",
+	Action: "Secret",
+
+	Prepare: func(cmd *cobra.Command, flags *RekeyFlags) {
+		cmd.Flags().BoolVarP(&flags.Master, "master", "m", false, "use the new password as master password to unlock all secrets")
+		cmd.Args = cobra.NoArgs
+	},
+
+	Run: func(ctx *app.Context[RekeyFlags, app.Empty]) error {
+		return core.RekeySecrets(ctx.Flags.Master)
+	},
+})
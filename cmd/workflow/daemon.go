@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+type DaemonFlags struct {
+	Foreground bool
+	Stop       bool
+}
+
+var Daemon = app.Register(&app.Command[DaemonFlags, app.Empty]{
+	Use:  "daemon [--foreground] [--stop]",
+	Desc: "Start daemon to run workflows with schedule",
+
+	Prepare: func(cmd *cobra.Command, flags *DaemonFlags) {
+		cmd.Flags().BoolVarP(&flags.Foreground, "foreground", "", false, "run daemon in foreground")
+		cmd.Flags().BoolVarP(&flags.Stop, "stop", "", false, "stop the running daemon")
+		cmd.Args = cobra.NoArgs
+	},
+
+	Run: func(ctx *app.Context[DaemonFlags, app.Empty]) error {
+		exe, err := os.Executable()
+		if err != nil {
+			return errors.Trace(err, "get executable")
+		}
+		if ctx.Flags.Foreground {
+			return core.RunDaemon(exe)
+		}
+
+		pid, err := core.DaemonPid()
+		if err != nil {
+			return err
+		}
+		if ctx.Flags.Stop {
+			if pid == 0 {
+				term.Warn("daemon is not running")
+				return nil
+			}
+			err = syscall.Kill(pid, syscall.SIGTERM)
+			return errors.Trace(err, "stop daemon")
+		}
+		if pid != 0 {
+			return fmt.Errorf("daemon is already running, pid %d", pid)
+		}
+
+		logPath := core.DaemonLogPath()
+		err = osutil.EnsureDir(filepath.Dir(logPath))
+		if err != nil {
+			return errors.Trace(err, "ensure daemon dir")
+		}
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Trace(err, "open daemon log file")
+		}
+		defer logFile.Close()
+
+		cmd := exec.Command(exe, "daemon", "--foreground")
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		// Detach from current session, so that the daemon won't be killed
+		// when the terminal is closed.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		err = cmd.Start()
+		if err != nil {
+			return errors.Trace(err, "start daemon")
+		}
+
+		for i := 0; i < 20; i++ {
+			pid, _ = core.DaemonPid()
+			if pid != 0 {
+				fmt.Printf("Daemon started, pid %d, log %s\n", pid, logPath)
+				return cmd.Process.Release()
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		cmd.Process.Kill()
+		return fmt.Errorf("daemon does not start in time, see %s", logPath)
+	},
+})
@@ -23,6 +23,10 @@ var Edit = app.Register(&app.Command[app.Empty, app.Empty]{
 	},
 
 	Run: func(ctx *app.Context[app.Empty, app.Empty]) error {
+		if ctx.Arg(0) == "history" {
+			// It cannot be run, "gz run history" shows the run history.
+			return errors.New("the workflow name \"history\" is reserved")
+		}
 		name := fmt.Sprintf("%s.yaml", ctx.Arg(0))
 		path := config.GetDir("workflows", name)
 		return app.Edit(path, config.DefaultWorkflow, name, func(s string) error {
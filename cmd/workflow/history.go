@@ -0,0 +1,61 @@
+package workflow
+
+import (
+	"os"
+	"time"
+
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+type HistoryFlags struct {
+	Limit int
+}
+
+var History = app.Register(&app.Command[HistoryFlags, app.Empty]{
+	Use:    "history [-n limit] [workflow]",
+	Desc:   "Show workflow run history",
+	Action: "Run",
+
+	Prepare: func(cmd *cobra.Command, flags *HistoryFlags) {
+		cmd.Flags().IntVarP(&flags.Limit, "limit", "n", 20, "max number of runs to show, 0 means all")
+		cmd.Args = cobra.MaximumNArgs(1)
+		cmd.ValidArgsFunction = app.Comp(app.CompWorkflow)
+	},
+
+	Run: func(ctx *app.Context[HistoryFlags, app.Empty]) error {
+		histories, err := core.ListWorkflowHistory(ctx.Arg(0))
+		if err != nil {
+			return err
+		}
+		if len(histories) == 0 {
+			term.Println("no history")
+			return nil
+		}
+		if ctx.Flags.Limit > 0 && len(histories) > ctx.Flags.Limit {
+			histories = histories[:ctx.Flags.Limit]
+		}
+
+		tb := table.NewWriter()
+		tb.SetOutputMirror(os.Stderr)
+		tb.SetStyle(table.StyleLight)
+		tb.AppendHeader(table.Row{"Start", "Workflow", "Trigger", "Duration", "Repos", "Status"})
+		for _, history := range histories {
+			status := term.Style("success", "green")
+			if history.Error != "" {
+				status = term.Style(history.Error, "red")
+			}
+			tb.AppendRow(table.Row{
+				history.Start.Local().Format("2006-01-02 15:04:05"),
+				history.Workflow, history.Trigger,
+				history.Duration.Round(time.Millisecond * 10),
+				history.Repos, status,
+			})
+		}
+		tb.Render()
+		return nil
+	},
+})
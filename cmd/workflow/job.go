@@ -0,0 +1,57 @@
+package workflow
+
+import (
+	"github.com/fioncat/gitzombie/cmd/app"
+	"github.com/fioncat/gitzombie/core"
+	"github.com/spf13/cobra"
+)
+
+var JobRun = app.Register(&app.Command[WorkflowFlags, app.Empty]{
+	Use:    "run [--repo remote[:glob]]... [--dir dir]... [-p key=value]... {job}",
+	Desc:   "Run job on current repo or selected repos",
+	Action: "Job",
+
+	Prepare: func(cmd *cobra.Command, flags *WorkflowFlags) {
+		cmd.Flags().StringArrayVarP(&flags.Repos, "repo", "r", nil, "select repos by remote[:glob], can be repeated")
+		cmd.Flags().StringArrayVarP(&flags.Dirs, "dir", "", nil, "select git dir, dir/* selects the repos under dir, can be repeated")
+		cmd.Flags().BoolVarP(&flags.Edit, "edit", "e", false, "edit repo to run")
+		cmd.Flags().StringVarP(&flags.LogPath, "log-path", "", "", "log file path")
+		cmd.Flags().StringArrayVarP(&flags.Params, "param", "p", nil, "job param, in key=value format, can be repeated")
+		cmd.Flags().IntVarP(&flags.Jobs, "jobs", "j", 0, "max number of jobs running at the same time, default is the number of cpus")
+		cmd.Flags().StringVarP(&flags.Report, "report", "", "", "write report to file, the format is json or markdown by extension, write both if no extension")
+		cmd.Flags().BoolVarP(&flags.DryRun, "dry-run", "", false, "print the repos and job to run without executing")
+
+		cmd.Args = cobra.ExactArgs(1)
+		cmd.ValidArgsFunction = app.Comp(app.CompJob)
+	},
+
+	Run: func(ctx *app.Context[WorkflowFlags, app.Empty]) error {
+		params, err := parseParams(ctx.Flags.Params)
+		if err != nil {
+			return err
+		}
+		wf := &core.Workflow{
+			Jobs: []*core.Job{{Uses: ctx.Arg(0), With: params}},
+		}
+		if len(ctx.Flags.Repos) > 0 || len(ctx.Flags.Dirs) > 0 {
+			wf.Select = &core.WorkflowSelect{
+				Repos: ctx.Flags.Repos,
+				Dirs:  ctx.Flags.Dirs,
+			}
+		} else {
+			ctx.Flags.Current = true
+		}
+		err = wf.Validate()
+		if err != nil {
+			return err
+		}
+
+		store, err := core.NewRepositoryStorage()
+		if err != nil {
+			return err
+		}
+		store.ReadOnly()
+		_, err = runWorkflow(ctx, store, wf, nil)
+		return err
+	},
+})
@@ -1,9 +1,18 @@
 package workflow
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dustin/go-humanize/english"
+	"github.com/fioncat/gitzombie/api"
 	"github.com/fioncat/gitzombie/cmd/app"
 	"github.com/fioncat/gitzombie/config"
 	"github.com/fioncat/gitzombie/core"
@@ -11,6 +20,7 @@ import (
 	"github.com/fioncat/gitzombie/pkg/osutil"
 	"github.com/fioncat/gitzombie/pkg/term"
 	"github.com/fioncat/gitzombie/pkg/worker"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -20,72 +30,206 @@ type WorkflowFlags struct {
 	Current bool
 
 	LogPath string
+
+	Params []string
+
+	Jobs int
+
+	Report string
+
+	DryRun bool
+
+	// Keep keeps the temporary clones of remote repos.
+	Keep bool
+
+	// Repos and Dirs select repos for "job run".
+	Repos []string
+	Dirs  []string
+
+	// Trigger is recorded in workflow history, empty for "job run" which is
+	// not recorded.
+	Trigger string
 }
 
 var Workflow = app.Register(&app.Command[WorkflowFlags, app.Empty]{
-	Use:  "run [-y] [-c] {workflow}",
+	Use:  "run [-c] [-p key=value]... [--dry-run] [--keep] {workflow}",
 	Desc: "Run workflow",
 
 	Prepare: func(cmd *cobra.Command, flags *WorkflowFlags) {
 		cmd.Flags().BoolVarP(&flags.Current, "current", "c", false, "run workflow on current repo")
 		cmd.Flags().BoolVarP(&flags.Edit, "edit", "e", false, "edit repo to run")
 		cmd.Flags().StringVarP(&flags.LogPath, "log-path", "", "", "log file path")
+		cmd.Flags().StringArrayVarP(&flags.Params, "param", "p", nil, "workflow input, in key=value format, can be repeated")
+		cmd.Flags().IntVarP(&flags.Jobs, "jobs", "j", 0, "max number of jobs running at the same time, default is the number of cpus")
+		cmd.Flags().StringVarP(&flags.Report, "report", "", "", "write report to file, the format is json or markdown by extension, write both if no extension")
+		cmd.Flags().BoolVarP(&flags.DryRun, "dry-run", "", false, "print the repos and jobs to run without executing")
+		cmd.Flags().BoolVarP(&flags.Keep, "keep", "", false, "keep the temporary clones of remote repos")
+		cmd.Flags().StringVarP(&flags.Trigger, "trigger", "", core.TriggerManual, "the trigger recorded in history")
+		cmd.Flags().MarkHidden("trigger")
 
 		cmd.Args = cobra.ExactArgs(1)
 		cmd.ValidArgsFunction = app.Comp(app.CompWorkflow)
 	},
 
 	Run: func(ctx *app.Context[WorkflowFlags, app.Empty]) error {
-		wf, err := core.GetWorkflow(ctx.Arg(0))
-		if err != nil {
-			return err
-		}
-		store, err := core.NewRepositoryStorage()
-		if err != nil {
+		if ctx.Flags.DryRun || ctx.Flags.Trigger == "" {
+			_, err := workflowRunName(ctx)
 			return err
 		}
-		store.ReadOnly()
 
-		if ctx.Flags.Current {
-			return workflowRunCurrent(store, wf)
+		// Record the history even if the workflow fails before running
+		// jobs, so that the failures of scheduled runs are visible.
+		start := time.Now()
+		var report *core.WorkflowReport
+		unlock, err := core.LockWorkflow(ctx.Arg(0))
+		if err == nil {
+			report, err = workflowRunName(ctx)
+			unlock()
 		}
+		recordHistory(ctx, start, report, err)
+		return err
+	},
+})
+
+func workflowRunName(ctx *app.Context[WorkflowFlags, app.Empty]) (*core.WorkflowReport, error) {
+	wf, err := core.GetWorkflow(ctx.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	store, err := core.NewRepositoryStorage()
+	if err != nil {
+		return nil, err
+	}
+	store.ReadOnly()
+
+	params, err := parseParams(ctx.Flags.Params)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := wf.ResolveInputs(params)
+	if err != nil {
+		return nil, err
+	}
+	return runWorkflow(ctx, store, wf, inputs)
+}
 
-		if wf.Select == nil {
-			term.Println("nothing to do")
-			return nil
+func recordHistory(ctx *app.Context[WorkflowFlags, app.Empty], start time.Time, report *core.WorkflowReport, err error) {
+	history := &core.WorkflowHistory{
+		Workflow: ctx.Arg(0),
+		Trigger:  ctx.Flags.Trigger,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	if report != nil {
+		history.Repos = len(report.Repos)
+		history.Failed = report.Failed()
+		if ctx.Flags.Report != "" {
+			history.Report, _ = filepath.Abs(ctx.Flags.Report)
 		}
+	}
+	if err != nil {
+		history.Error = err.Error()
+	}
+	err = core.AppendWorkflowHistory(history)
+	if err != nil {
+		term.Warn("record history: %v", err)
+	}
+}
+
+// runWorkflow runs the workflow on the selected repos, or on current repo
+// if the "--current" flag is set. The report is nil if no repo is run.
+func runWorkflow(ctx *app.Context[WorkflowFlags, app.Empty], store *core.RepositoryStorage, wf *core.Workflow, inputs osutil.Env) (*core.WorkflowReport, error) {
+	if ctx.Flags.Current {
+		return nil, workflowRunCurrent(ctx, store, wf, inputs)
+	}
+
+	if wf.Select == nil {
+		term.Println("nothing to do")
+		return nil, nil
+	}
 
-		items, err := wf.Select.Match(store)
+	items, err := wf.Select.Match(store)
+	if err != nil {
+		return nil, err
+	}
+	remoteItems, err := wf.Select.MatchRemote(items, listRemoteRepos)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, remoteItems...)
+	if len(items) == 0 {
+		term.Println("no repo selected")
+		return nil, nil
+	}
+	if ctx.Flags.Edit {
+		items, err = term.EditItems(config.Get().Editor, items,
+			func(item *core.WorkflowMatchItem) string {
+				return item.Name()
+			})
 		if err != nil {
-			return err
-		}
-		if len(items) == 0 {
-			term.Println("no repo selected")
-			return nil
+			return nil, err
 		}
-		if ctx.Flags.Edit {
-			items, err = term.EditItems(config.Get().Editor, items,
-				func(item *core.WorkflowMatchItem) string {
-					return item.Path
-				})
-			if err != nil {
-				return err
-			}
+	}
+
+	for _, item := range items {
+		for key, value := range inputs {
+			item.Env[key] = value
 		}
+	}
 
-		repoWord := english.Plural(len(items), "repo", "repos")
-		term.ConfirmExit("Do you want to run workflow %s for %s", ctx.Arg(0), repoWord)
+	if ctx.Flags.DryRun {
+		printPlan(wf.Jobs, items)
+		return nil, nil
+	}
 
-		return workflowRun(ctx, wf.Jobs, items)
-	},
-})
+	repoWord := english.Plural(len(items), "repo", "repos")
+	term.ConfirmExit("Do you want to run %s for %s", ctx.Arg(0), repoWord)
+
+	err = wf.ResolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return workflowRun(ctx, wf.Jobs, items)
+}
+
+// listRemoteRepos lists the repo names in group for "remote_repos", the
+// archived repos are ignored.
+func listRemoteRepos(remote *core.Remote, group string) ([]string, error) {
+	repos, err := api.ListRepos(remote, group)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		if repo.Archived {
+			continue
+		}
+		names = append(names, repo.Name)
+	}
+	return names, nil
+}
+
+func parseParams(params []string) (map[string]string, error) {
+	result := make(map[string]string, len(params))
+	for _, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid param %q, should be key=value", param)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
 
-func workflowRunCurrent(store *core.RepositoryStorage, wf *core.Workflow) error {
+func workflowRunCurrent(ctx *app.Context[WorkflowFlags, app.Empty], store *core.RepositoryStorage, wf *core.Workflow, inputs osutil.Env) error {
 	path, err := os.Getwd()
 	if err != nil {
 		return errors.Trace(err, "get pwd")
 	}
-	env := make(osutil.Env)
+	env := make(osutil.Env, len(inputs))
+	for key, value := range inputs {
+		env[key] = value
+	}
 	repo, _ := store.GetByPath(path)
 	if repo != nil {
 		var remote *core.Remote
@@ -100,20 +244,33 @@ func workflowRunCurrent(store *core.RepositoryStorage, wf *core.Workflow) error
 			return errors.Trace(err, "set env for repo %s", repo.FullName())
 		}
 	}
-	for _, job := range wf.Jobs {
-		err = job.Execute(path, env)
-		if err != nil {
-			return err
-		}
+	if ctx.Flags.DryRun {
+		printPlan(wf.Jobs, []*core.WorkflowMatchItem{{
+			Path: path,
+			Env:  env,
+			Repo: repo,
+		}})
+		return nil
 	}
-	return nil
+	err = wf.ResolveSecrets()
+	if err != nil {
+		return err
+	}
+	return core.ExecuteJobs(wf.Jobs, path, env)
 }
 
-func workflowRun(ctx *app.Context[WorkflowFlags, app.Empty], jobs []*core.Job, items []*core.WorkflowMatchItem) error {
+func workflowRun(ctx *app.Context[WorkflowFlags, app.Empty], jobs []*core.Job, items []*core.WorkflowMatchItem) (*core.WorkflowReport, error) {
+	// Catch the interrupt before cloning, so that a canceled run stops the
+	// pending tasks and still removes the temporary clones.
+	runCtx := api.Context()
+	cloneDir, err := createCloneDir(items)
+	if err != nil {
+		return nil, err
+	}
 	tasks := make([]*worker.Task[core.WorkflowMatchItem], len(items))
 	for i, item := range items {
 		tasks[i] = &worker.Task[core.WorkflowMatchItem]{
-			Name:  item.Path,
+			Name:  item.Name(),
 			Value: item,
 		}
 	}
@@ -125,14 +282,258 @@ func workflowRun(ctx *app.Context[WorkflowFlags, app.Empty], jobs []*core.Job, i
 		LogPath: ctx.Flags.LogPath,
 	}
 
+	concurrency := ctx.Flags.Jobs
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	core.SetJobConcurrency(concurrency)
+
+	report := &core.WorkflowReport{
+		Workflow: ctx.Arg(0),
+		Start:    time.Now(),
+		Repos:    make([]*core.RepoReport, len(items)),
+	}
+	index := make(map[*core.WorkflowMatchItem]int, len(items))
+	for i, item := range items {
+		index[item] = i
+	}
+
 	core.MuteJob = true
-	return w.Run(func(task *worker.Task[core.WorkflowMatchItem]) error {
-		for _, job := range jobs {
-			err := job.Execute(task.Value.Path, task.Value.Env)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+	runErr := w.Run(func(task *worker.Task[core.WorkflowMatchItem]) error {
+		repoReport, err := runWorkflowTask(runCtx, task, jobs, cloneDir, ctx.Flags.Keep)
+		report.Repos[index[task.Value]] = repoReport
+		return err
 	})
+	report.Duration = time.Since(report.Start)
+	removeCloneDir(cloneDir, ctx.Flags.Keep)
+
+	printReport(report)
+	if ctx.Flags.Report != "" {
+		paths, err := report.Write(ctx.Flags.Report)
+		if err != nil {
+			return report, errors.Trace(err, "write report")
+		}
+		for _, path := range paths {
+			term.Printf("write report to %s", path)
+		}
+	}
+	return report, runErr
+}
+
+// runWorkflowTask clones the repo if needed and runs the jobs in it.
+func runWorkflowTask(ctx context.Context, task *worker.Task[core.WorkflowMatchItem], jobs []*core.Job, cloneDir string, keep bool) (*core.RepoReport, error) {
+	start := time.Now()
+	err := ctx.Err()
+	if err != nil {
+		return core.NewRepoReport(task.Value, &core.JobRun{}, 0, err), err
+	}
+	if task.Value.Clone != "" {
+		task.SetStatus("cloning")
+		err = task.Value.CloneTemp(cloneDir)
+		if err != nil {
+			return core.NewRepoReport(task.Value, &core.JobRun{}, time.Since(start), err), err
+		}
+		if !keep {
+			defer os.RemoveAll(task.Value.Path)
+		}
+	}
+
+	progress := &jobProgress{
+		jobs:   jobs,
+		status: make(map[*core.Job]core.JobStatus, len(jobs)),
+	}
+	run := &core.JobRun{
+		Jobs: jobs,
+		Root: task.Value.Path,
+		Env:  task.Value.Env,
+
+		Context: ctx,
+
+		OnStatus: func(job *core.Job, status core.JobStatus) {
+			task.SetStatus(progress.update(job, status))
+		},
+	}
+	err = run.Execute()
+	return core.NewRepoReport(task.Value, run, time.Since(start), err), err
+}
+
+// createCloneDir creates the temporary dir to clone the remote repos, empty
+// if there is no remote repo.
+func createCloneDir(items []*core.WorkflowMatchItem) (string, error) {
+	for _, item := range items {
+		if item.Clone != "" {
+			dir, err := os.MkdirTemp("", "gitzombie-remote-")
+			return dir, errors.Trace(err, "create clone dir")
+		}
+	}
+	return "", nil
+}
+
+func removeCloneDir(dir string, keep bool) {
+	if dir == "" {
+		return
+	}
+	if keep {
+		term.Printf("keep clones in %s", dir)
+		return
+	}
+	err := os.RemoveAll(dir)
+	if err != nil {
+		term.Warn("remove clone dir: %v", err)
+	}
+}
+
+func printReport(report *core.WorkflowReport) {
+	tb := table.NewWriter()
+	tb.SetOutputMirror(os.Stderr)
+	tb.SetStyle(table.StyleLight)
+	tb.AppendHeader(table.Row{"Repo", "Status", "Duration", "Jobs"})
+	for _, repo := range report.Repos {
+		status := string(repo.Status)
+		if repo.Status == core.JobFailure {
+			status = term.Style(status, "red")
+		} else {
+			status = term.Style(status, "green")
+		}
+		tb.AppendRow(table.Row{repo.Name(), status,
+			repo.Duration.Round(time.Millisecond * 10), repo.JobsSummary()})
+	}
+	term.Println()
+	tb.Render()
+}
+
+// printPlan prints the env and the jobs that would be executed in each
+// repo, nothing is executed.
+func printPlan(jobs []*core.Job, items []*core.WorkflowMatchItem) {
+	var runCount int
+	for i, item := range items {
+		if i > 0 {
+			term.Println()
+		}
+		runCount += printRepoPlan(jobs, item)
+	}
+	term.Println()
+	term.Printf("%s would run on %s (dry run)",
+		english.Plural(runCount, "job", "jobs"),
+		english.Plural(len(items), "repo", "repos"))
+}
+
+// printRepoPlan prints the plan of one repo, returns the number of jobs that
+// would run.
+func printRepoPlan(jobs []*core.Job, item *core.WorkflowMatchItem) int {
+	title := item.Path
+	switch {
+	case item.Clone != "":
+		title = fmt.Sprintf("%s (clone)", item.Clone)
+
+	case item.Repo != nil:
+		title = fmt.Sprintf("%s (%s)", item.Path, item.Repo.FullName())
+	}
+	term.PrintOperation(title)
+
+	keys := sortedKeys(item.Env)
+	if len(keys) > 0 {
+		term.Println("Env:")
+	}
+	for _, key := range keys {
+		term.Printf("  %s=%s", key, term.Style(item.Env[key], "green"))
+	}
+
+	var runCount int
+	run := &core.JobRun{Jobs: jobs, Root: item.Path, Env: item.Env}
+	term.Println("Jobs:")
+	for _, plan := range run.Plan() {
+		switch {
+		case plan.Error != "":
+			term.Printf("  %s %s: %s", term.Style("x", "red"), plan.Name, term.Style(plan.Error, "red"))
+
+		case plan.Skip != "":
+			term.Printf("  %s %s: skip, %s", term.Style("-", "yellow"), plan.Name, plan.Skip)
+
+		default:
+			runCount++
+			printJobPlan(plan, item.Path)
+		}
+	}
+	return runCount
+}
+
+func printJobPlan(plan *core.JobPlan, root string) {
+	args := plan.Args
+	script := args[len(args)-1]
+	if strings.Contains(script, "\n") {
+		// Print the multi-line script below the command.
+		args = args[:len(args)-1]
+	}
+	mark := term.Style("+", "green")
+	if plan.Pending != "" {
+		mark = term.Style("?", "yellow")
+	}
+	term.Printf("  %s %s: %s", mark, plan.Name, term.Style(shellJoin(args), "bold"))
+	if len(args) < len(plan.Args) {
+		script = strings.TrimSuffix(script, "\n")
+		for _, line := range strings.Split(script, "\n") {
+			term.Printf("    | %s", line)
+		}
+	}
+	if plan.Pending != "" {
+		term.Printf("    if %s (evaluated after clone)", plan.Pending)
+	}
+	if plan.Dir != root {
+		term.Printf("    in %s", plan.Dir)
+	}
+	for _, key := range sortedKeys(plan.Params) {
+		term.Printf("    with %s=%s", key, term.Style(plan.Params[key], "green"))
+	}
+}
+
+func sortedKeys(env osutil.Env) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"$`\\|&;<>()*?[]{}~#!") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// jobProgress formats the status of jobs in one repo for tracker, such as
+// "(1/3) lint, test", the running jobs are listed.
+type jobProgress struct {
+	lock sync.Mutex
+
+	jobs   []*core.Job
+	status map[*core.Job]core.JobStatus
+}
+
+func (p *jobProgress) update(job *core.Job, status core.JobStatus) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.status[job] = status
+
+	var done int
+	var running []string
+	for _, job := range p.jobs {
+		switch p.status[job] {
+		case "", core.JobPending:
+
+		case core.JobRunning:
+			running = append(running, job.Name)
+
+		default:
+			done++
+		}
+	}
+	return fmt.Sprintf("(%d/%d) %s", done, len(p.jobs), strings.Join(running, ", "))
 }
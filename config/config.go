@@ -18,6 +18,8 @@ type Config struct {
 
 	SearchLimit int `toml:"search_limit" default:"200"`
 
+	CacheTTL int `toml:"cache_ttl" default:"86400"`
+
 	Editor string `toml:"editor" default:"vim"`
 }
 
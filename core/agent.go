@@ -0,0 +1,287 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+// The secret agent caches decrypted secrets in memory, so that we don't need
+// to input password every time. The agent listens on a unix socket that only
+// the current user can access, every connection handles one json request.
+
+const (
+	agentGet    = "get"
+	agentSet    = "set"
+	agentDelete = "delete"
+	agentLock   = "lock"
+)
+
+const agentDialTimeout = time.Millisecond * 200
+
+type agentRequest struct {
+	Op string `json:"op"`
+
+	Key string `json:"key,omitempty"`
+
+	// The encrypted value in secrets file, used to make sure that the cached
+	// value is not stale.
+	Cipher string `json:"cipher,omitempty"`
+
+	Value string `json:"value,omitempty"`
+}
+
+type agentResponse struct {
+	Found bool   `json:"found,omitempty"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type agentEntry struct {
+	cipher string
+	value  []byte
+
+	lastUsed time.Time
+}
+
+func (e *agentEntry) wipe() {
+	for i := range e.value {
+		e.value[i] = 0
+	}
+}
+
+type SecretAgent struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*agentEntry
+}
+
+func AgentSocketPath() string {
+	return config.GetLocalDir("agent", "secret.sock")
+}
+
+// RunSecretAgent starts the secret agent and blocks until it receives
+// interrupt signal. The cached secret will be wiped after not being used for
+// timeout.
+func RunSecretAgent(timeout time.Duration) error {
+	path := AgentSocketPath()
+	if AgentRunning() {
+		return errors.New("secret agent is already running")
+	}
+
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Trace(err, "ensure agent dir")
+	}
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return errors.Trace(err, "chmod agent dir")
+	}
+	// The socket of previous agent might not be cleaned.
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err, "remove stale agent socket")
+	}
+
+	oldMask := syscall.Umask(0077)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return errors.Trace(err, "listen agent socket")
+	}
+	defer listener.Close()
+
+	agent := &SecretAgent{
+		timeout: timeout,
+		entries: make(map[string]*agentEntry),
+	}
+	go agent.expireLoop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-sigCh
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			agent.lock()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return errors.Trace(err, "accept agent connection")
+		}
+		go agent.handle(conn)
+	}
+}
+
+func (agent *SecretAgent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	var req agentRequest
+	var resp agentResponse
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		resp.Error = fmt.Sprintf("decode request: %v", err)
+	} else {
+		resp = agent.do(&req)
+	}
+	json.NewEncoder(conn).Encode(&resp)
+}
+
+func (agent *SecretAgent) do(req *agentRequest) agentResponse {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	switch req.Op {
+	case agentGet:
+		entry := agent.entries[req.Key]
+		if entry == nil || entry.cipher != req.Cipher {
+			return agentResponse{}
+		}
+		entry.lastUsed = time.Now()
+		return agentResponse{Found: true, Value: string(entry.value)}
+
+	case agentSet:
+		if entry := agent.entries[req.Key]; entry != nil {
+			entry.wipe()
+		}
+		agent.entries[req.Key] = &agentEntry{
+			cipher:   req.Cipher,
+			value:    []byte(req.Value),
+			lastUsed: time.Now(),
+		}
+
+	case agentDelete:
+		if entry := agent.entries[req.Key]; entry != nil {
+			entry.wipe()
+			delete(agent.entries, req.Key)
+		}
+
+	case agentLock:
+		agent.wipeAll()
+
+	default:
+		return agentResponse{Error: fmt.Sprintf("unknown op %q", req.Op)}
+	}
+	return agentResponse{}
+}
+
+func (agent *SecretAgent) lock() {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	agent.wipeAll()
+}
+
+func (agent *SecretAgent) wipeAll() {
+	for key, entry := range agent.entries {
+		entry.wipe()
+		delete(agent.entries, key)
+	}
+}
+
+func (agent *SecretAgent) expireLoop() {
+	interval := agent.timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		agent.mu.Lock()
+		for key, entry := range agent.entries {
+			if time.Since(entry.lastUsed) >= agent.timeout {
+				entry.wipe()
+				delete(agent.entries, key)
+			}
+		}
+		agent.mu.Unlock()
+	}
+}
+
+// AgentRunning reports whether the secret agent is running.
+func AgentRunning() bool {
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), agentDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// callAgent sends request to the secret agent. If the agent is not running,
+// returns nil response without error.
+func callAgent(req *agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), agentDialTimeout)
+	if err != nil {
+		return nil, nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return nil, errors.Trace(err, "send agent request")
+	}
+	var resp agentResponse
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return nil, errors.Trace(err, "read agent response")
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("secret agent: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+func agentGetSecret(key string, s *Secret) (string, bool) {
+	resp, err := callAgent(&agentRequest{
+		Op:     agentGet,
+		Key:    key,
+		Cipher: s.Value,
+	})
+	if err != nil || resp == nil || !resp.Found {
+		// The agent is only a cache, fallback to input password.
+		return "", false
+	}
+	return resp.Value, true
+}
+
+func agentSetSecret(key string, s *Secret, value string) {
+	callAgent(&agentRequest{
+		Op:     agentSet,
+		Key:    key,
+		Cipher: s.Value,
+		Value:  value,
+	})
+}
+
+func agentDeleteSecret(key string) {
+	callAgent(&agentRequest{
+		Op:  agentDelete,
+		Key: key,
+	})
+}
+
+// LockSecretAgent wipes all secrets cached in the agent. Returns false if the
+// agent is not running.
+func LockSecretAgent() (bool, error) {
+	resp, err := callAgent(&agentRequest{Op: agentLock})
+	if err != nil {
+		return false, err
+	}
+	return resp != nil, nil
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSecretAgent(t *testing.T) {
+	agent := &SecretAgent{
+		timeout: time.Minute,
+		entries: make(map[string]*agentEntry),
+	}
+	agent.do(&agentRequest{Op: agentSet, Key: "token", Cipher: "abc", Value: "secret"})
+
+	resp := agent.do(&agentRequest{Op: agentGet, Key: "token", Cipher: "abc"})
+	if !resp.Found || resp.Value != "secret" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// The secret was changed in file, the cache should not be used.
+	resp = agent.do(&agentRequest{Op: agentGet, Key: "token", Cipher: "def"})
+	if resp.Found {
+		t.Fatal("stale secret should not be found")
+	}
+
+	entry := agent.entries["token"]
+	agent.do(&agentRequest{Op: agentLock})
+	resp = agent.do(&agentRequest{Op: agentGet, Key: "token", Cipher: "abc"})
+	if resp.Found {
+		t.Fatal("secret should be wiped after lock")
+	}
+	for _, b := range entry.value {
+		if b != 0 {
+			t.Fatal("secret value is not wiped")
+		}
+	}
+}
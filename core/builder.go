@@ -58,7 +58,15 @@ func GetBuilder(name string) (*Builder, error) {
 }
 
 func (b *Builder) Validate() error {
-	return validate.Do(b)
+	err := validate.Do(b)
+	if err != nil {
+		return err
+	}
+	err = validateJobs(b.Create)
+	if err != nil {
+		return err
+	}
+	return validateJobs(b.Init)
 }
 
 func (b *Builder) Prepare(remote *Remote, repo *Repository) error {
@@ -126,11 +134,9 @@ func (b *Builder) Execute() error {
 }
 
 func (b *Builder) executeJobs(root string, jobs []*Job) error {
-	for _, job := range jobs {
-		err := job.Execute(root, b.env)
-		if err != nil {
-			return wrapJobCmdError(err)
-		}
+	err := ExecuteJobs(jobs, root, b.env)
+	if err != nil {
+		return wrapJobCmdError(err)
 	}
 	return nil
 }
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// The daemon runs the workflows with schedule in background. It checks the
+// workflows periodically by wall clock rather than sleeping until the next
+// run, so that the runs missed during system sleep are caught up (once)
+// after wakeup. Every run is a "gz run" process, which holds the workflow
+// lock and records history.
+
+const daemonCheckInterval = time.Second * 30
+
+func DaemonLogPath() string {
+	return config.GetLocalDir("daemon", "daemon.log")
+}
+
+func daemonLockPath() string {
+	return config.GetLocalDir("daemon", "daemon.lock")
+}
+
+// DaemonReportPath returns the report path (without extension) of the
+// workflow run by daemon.
+func DaemonReportPath(name string) string {
+	return config.GetLocalDir("daemon", "runs", name)
+}
+
+// DaemonPid returns the pid of the running daemon, zero if the daemon is not
+// running.
+func DaemonPid() (int, error) {
+	path := daemonLockPath()
+	file, err := lockFile(path)
+	if err == nil {
+		file.Close()
+		return 0, nil
+	}
+	if err != errLocked {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, errors.Trace(err, "read daemon lock file")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid in daemon lock file %s", path)
+	}
+	return pid, nil
+}
+
+type daemon struct {
+	exe   string
+	start time.Time
+
+	mu sync.Mutex
+	// last is the last time the workflow was triggered.
+	last    map[string]time.Time
+	running map[string]bool
+}
+
+// RunDaemon runs the daemon and blocks until it receives interrupt signal,
+// exe is the gitzombie binary to run workflows.
+func RunDaemon(exe string) error {
+	lock, err := lockFile(daemonLockPath())
+	if err != nil {
+		if err == errLocked {
+			return errors.New("daemon is already running")
+		}
+		return errors.Trace(err, "lock daemon")
+	}
+	defer lock.Close()
+	err = lock.Truncate(0)
+	if err != nil {
+		return errors.Trace(err, "truncate daemon lock file")
+	}
+	_, err = fmt.Fprintf(lock, "%d\n", os.Getpid())
+	if err != nil {
+		return errors.Trace(err, "write daemon pid")
+	}
+
+	d := &daemon{
+		exe:     exe,
+		start:   time.Now(),
+		last:    make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+	d.log("daemon started, pid %d", os.Getpid())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	ticker := time.NewTicker(daemonCheckInterval)
+	defer ticker.Stop()
+
+	d.check(time.Now())
+	for {
+		select {
+		case sig := <-sigCh:
+			d.log("daemon stopped by %v", sig)
+			return nil
+
+		case <-ticker.C:
+			d.check(time.Now())
+		}
+	}
+}
+
+func (d *daemon) log(msg string, args ...any) {
+	msg = fmt.Sprintf(msg, args...)
+	fmt.Fprintf(os.Stderr, "%s %s\n", time.Now().Format(time.RFC3339), msg)
+}
+
+// check starts the workflows that are due at now. The workflows are loaded
+// every time, so that the changes are applied without restarting daemon.
+func (d *daemon) check(now time.Time) {
+	names, err := ListWorkflowNames()
+	if err != nil {
+		d.log("list workflows: %v", err)
+		return
+	}
+	for _, name := range names {
+		wf, err := GetWorkflow(name)
+		if err != nil {
+			d.log("load workflow %s: %v", name, err)
+			continue
+		}
+		if wf.schedule == nil {
+			continue
+		}
+
+		d.mu.Lock()
+		running := d.running[name]
+		d.mu.Unlock()
+		if running {
+			continue
+		}
+		last, err := d.lastRun(name)
+		if err != nil {
+			d.log("get last run of workflow %s: %v", name, err)
+			continue
+		}
+		next := wf.schedule.Next(last)
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+		if now.Sub(next) > daemonCheckInterval*2 {
+			d.log("catch up workflow %s missed at %s", name, next.Format(time.RFC3339))
+		}
+
+		d.mu.Lock()
+		d.last[name] = now
+		d.running[name] = true
+		d.mu.Unlock()
+		go d.run(name)
+	}
+}
+
+// lastRun returns the last time the workflow was triggered by schedule, the
+// daemon start time is used if the workflow has never been scheduled.
+func (d *daemon) lastRun(name string) (time.Time, error) {
+	d.mu.Lock()
+	last, ok := d.last[name]
+	d.mu.Unlock()
+	if ok {
+		return last, nil
+	}
+
+	histories, err := ListWorkflowHistory(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	last = d.start
+	for _, history := range histories {
+		if history.Trigger == TriggerSchedule {
+			last = history.Start
+			break
+		}
+	}
+	d.mu.Lock()
+	d.last[name] = last
+	d.mu.Unlock()
+	return last, nil
+}
+
+func (d *daemon) run(name string) {
+	defer func() {
+		d.mu.Lock()
+		d.running[name] = false
+		d.mu.Unlock()
+	}()
+
+	reportPath := DaemonReportPath(name)
+	logPath := reportPath + ".log"
+	err := osutil.EnsureDir(config.GetLocalDir("daemon", "runs"))
+	if err != nil {
+		d.log("ensure runs dir: %v", err)
+		return
+	}
+	out, err := os.Create(logPath)
+	if err != nil {
+		d.log("create log file for workflow %s: %v", name, err)
+		return
+	}
+	defer out.Close()
+
+	d.log("run workflow %s", name)
+	start := time.Now()
+	cmd := exec.Command(d.exe, "run", name, "--yes",
+		"--trigger", TriggerSchedule, "--report", reportPath)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	duration := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		d.log("workflow %s failed after %v: %v, see %s", name, duration, err, logPath)
+		return
+	}
+	d.log("workflow %s done in %v", name, duration)
+}
@@ -3,21 +3,81 @@ package core
 import (
 	"bytes"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/fioncat/gitzombie/config"
 	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/expr"
 	"github.com/fioncat/gitzombie/pkg/osutil"
 	"github.com/fioncat/gitzombie/pkg/term"
+	"golang.org/x/sys/unix"
 )
 
 var MuteJob bool
 
 type Job struct {
-	Name string `yaml:"name" validate:"required"`
+	Name string `yaml:"name" validate:"required_without=Uses"`
 	Run  string `yaml:"run"`
 
+	// Uses executes the job file in "jobs" dir, default is the job name if
+	// there is no Run or Command. The params declared in job file are
+	// passed by With, see JobFile.
+	Uses string            `yaml:"uses" validate:"excluded_with=Run Command"`
+	With map[string]string `yaml:"with" validate:"excluded_with=Run Command"`
+
+	// Command is executed directly without shell, the "$VAR" in args are
+	// expanded by job env and secrets.
+	Command []string `yaml:"command" validate:"excluded_with=Run"`
+
+	// Shell is the interpreter to execute Run or job file, can have args,
+	// such as "zsh", "python3" or "bash -eo pipefail". Default is bash.
+	Shell string `yaml:"shell"`
+
+	// Workdir is the directory to execute the job, relative to repo. It
+	// cannot be outside of the repo.
+	Workdir string `yaml:"workdir"`
+
+	// Needs are the jobs that must be finished before this job. If any job
+	// in the list has needs, the jobs without dependency are executed
+	// concurrently, see JobRun.
+	Needs []string `yaml:"needs"`
+
 	RequireEnv []string `yaml:"require_env"`
+
+	// If is an expression to decide whether to run the job, such as
+	// `exists("go.mod") && branch == "main"`, see jobContext.
+	If string `yaml:"if"`
+
+	// Secrets maps env name to secret key, the values are only exported to
+	// the job's process and are masked in the captured output.
+	Secrets map[string]string `yaml:"secrets"`
+
+	// Timeout kills the job and all its child processes if it runs too
+	// long, such as "10m". Zero means no timeout.
+	Timeout time.Duration `yaml:"timeout" validate:"gte=0"`
+
+	// Retries is the number of times to re-run the job after failure,
+	// waiting RetryDelay between attempts.
+	Retries    int           `yaml:"retries" validate:"gte=0"`
+	RetryDelay time.Duration `yaml:"retry_delay" validate:"gte=0"`
+
+	// ContinueOnError ignores the failure of job, the following jobs are
+	// still executed.
+	ContinueOnError bool `yaml:"continue_on_error"`
+
+	secrets osutil.Env
+	params  osutil.Env
+
+	cond *expr.Expr
 }
 
 type JobError struct {
@@ -25,57 +85,346 @@ type JobError struct {
 	Path string
 	Err  error
 	out  string
+
+	// Attempts is the number of times the job was executed.
+	Attempts int
+	// Timeout is not zero if the last attempt was killed by timeout.
+	Timeout time.Duration
 }
 
 func (err *JobError) Error() string {
-	return fmt.Sprintf("failed to execute job %s on %s: %v", err.Name, err.Path, err.Err)
+	msg := fmt.Sprintf("failed to execute job %s on %s", err.Name, err.Path)
+	if err.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", err.Attempts)
+	}
+	if err.Timeout > 0 {
+		return fmt.Sprintf("%s: killed by timeout %v", msg, err.Timeout)
+	}
+	return fmt.Sprintf("%s: %v", msg, err.Err)
 }
 
 func (err *JobError) Out() string {
 	return err.out
 }
 
+// ExitCode returns the exit code of the job process, -1 if the process was
+// killed or not started.
+func (err *JobError) ExitCode() int {
+	if exitErr, ok := err.Err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// The interpreters for job files without shell, other files are executed
+// directly (by shebang).
+var jobFileShells = map[string]string{
+	shExt:   "bash",
+	".bash": "bash",
+	".zsh":  "zsh",
+	".py":   "python3",
+	".rb":   "ruby",
+	".js":   "node",
+	".pl":   "perl",
+}
+
+// The flags to execute inline script for interpreters, default is "-c".
+var inlineScriptFlags = map[string]string{
+	"node":  "-e",
+	"deno":  "eval",
+	"ruby":  "-e",
+	"perl":  "-e",
+	"php":   "-r",
+	"lua":   "-e",
+	"julia": "-e",
+}
+
+// ListJobNames lists job files in "jobs" dir. The extensions in
+// jobFileShells are not a part of the name, the other files are named by
+// their full file names, see GetJobPath.
 func ListJobNames() ([]string, error) {
-	return listConfigObjects("jobs", shExt)
+	rootDir := config.GetDir("jobs")
+	var names []string
+	set := make(map[string]struct{})
+	err := filepath.Walk(rootDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			// Skip hidden files, such as ".DS_Store".
+			return nil
+		}
+		if JobFileShell(path) != "" {
+			path = strings.TrimSuffix(path, filepath.Ext(path))
+		}
+		name, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return errors.Trace(err, "get rel path for job %s", path)
+		}
+		if _, ok := set[name]; ok {
+			return nil
+		}
+		set[name] = struct{}{}
+		names = append(names, name)
+		return nil
+	})
+	return names, err
 }
 
+// GetJobPath finds the job file by exact name: "{name}.sh" is preferred,
+// then "{name}.{ext}" for the other extensions in jobFileShells, and the
+// file "{name}" itself. So the other files, such as editor backup
+// "lint.sh.bak", are not matched by "lint".
 func GetJobPath(name string) (string, error) {
-	path := getConfigObjectPath("jobs", shExt, name)
-	exists, err := osutil.FileExists(path)
-	if err != nil {
-		return "", err
+	exts := make([]string, 0, len(jobFileShells))
+	for ext := range jobFileShells {
+		if ext != shExt {
+			exts = append(exts, ext)
+		}
 	}
-	if !exists {
-		return "", fmt.Errorf("cannot find job %s", name)
+	sort.Strings(exts)
+	exts = append([]string{shExt}, exts...)
+	exts = append(exts, "")
+
+	for _, ext := range exts {
+		path := getConfigObjectPath("jobs", ext, name)
+		exists, err := osutil.FileExists(path)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return path, nil
+		}
 	}
-	return path, nil
+	return "", fmt.Errorf("cannot find job %s", name)
+}
+
+// JobFileShell returns the interpreter of job file by its extension, empty
+// if the file is executed directly (by shebang).
+func JobFileShell(path string) string {
+	return jobFileShells[filepath.Ext(path)]
 }
 
 func (job *Job) Execute(root string, env osutil.Env) error {
-	if key, skip := job.Skip(env); skip {
+	return ExecuteJobs([]*Job{job}, root, env)
+}
+
+func (job *Job) run(ctx *jobContext) (*JobReport, error) {
+	report := &JobReport{Name: job.Name, Status: JobSkipped}
+	if key, skip := job.Skip(ctx.env); skip {
 		job.Say("skip job %q because of unbound env %q", job.Name, key)
-		return nil
+		return report, nil
+	}
+	report.Status = JobFailure
+	cond, err := job.condition()
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	if cond != nil {
+		ok, err := cond.Eval(ctx)
+		if err != nil {
+			report.Error = err.Error()
+			return report, errors.Trace(err, "job %s", job.Name)
+		}
+		if !ok {
+			job.Say("skip job %q because %q is false", job.Name, cond)
+			report.Status = JobSkipped
+			return report, nil
+		}
+	}
+
+	ctx.report(job, JobRunning)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if attempt == 1 {
+			job.Say("running %s", job.Name)
+		} else {
+			job.Say("retrying %s (%d/%d)", job.Name, attempt-1, job.Retries)
+		}
+		report.Attempts = attempt
+		// The slot is only held while the job process is running, so that
+		// waiting for retry does not block the jobs of other repos.
+		acquireJobSlot()
+		report.Output, err = job.execute(ctx.root, ctx.env, ctx.interrupt.Done())
+		releaseJobSlot()
+		jobErr, ok := err.(*JobError)
+		if !ok {
+			break
+		}
+		jobErr.Attempts = attempt
+		if attempt > job.Retries || !ctx.wait(job.RetryDelay) {
+			break
+		}
+	}
+	report.Duration = time.Since(start)
+	if err != nil {
+		report.Error = err.Error()
+		report.ExitCode = -1
+		if jobErr, ok := err.(*JobError); ok {
+			report.ExitCode = jobErr.ExitCode()
+		}
+		if job.ContinueOnError {
+			job.Say("ignore failure of job %s: %v", job.Name, err)
+			return report, nil
+		}
+		return report, err
 	}
+	report.Status = JobSuccess
+	return report, nil
+}
 
+// execute runs the job once, returns the output captured if MuteJob. The
+// job is killed when interrupt is closed.
+func (job *Job) execute(root string, env osutil.Env, interrupt <-chan struct{}) (string, error) {
 	var out bytes.Buffer
 	cmd, err := job.Cmd(root, env, &out)
 	if err != nil {
-		return err
+		return "", err
 	}
-	job.Say("running %s", job.Name)
 
-	err = cmd.Run()
+	// With timeout, run the job in its own process group, so that the
+	// children can be killed together. The job not muted is given the
+	// terminal's foreground, so it can still receive Ctrl-C and read stdin.
+	group := job.Timeout > 0
+	if group {
+		attr := &syscall.SysProcAttr{Setpgid: true}
+		if !MuteJob && isTerminalForeground() {
+			attr.Foreground = true
+			// The Ctty is the fd in child, it is the stdin.
+			attr.Ctty = 0
+			defer restoreTerminalForeground()
+		}
+		cmd.SysProcAttr = attr
+		cmd.WaitDelay = time.Second
+	}
+	err = cmd.Start()
 	if err != nil {
-		return &JobError{
+		return "", err
+	}
+
+	pid := cmd.Process.Pid
+	kill := func() {
+		if group {
+			syscall.Kill(-pid, syscall.SIGKILL)
+			return
+		}
+		cmd.Process.Kill()
+	}
+
+	var timeout atomic.Bool
+	var timer *time.Timer
+	if job.Timeout > 0 {
+		timer = time.AfterFunc(job.Timeout, func() {
+			timeout.Store(true)
+			kill()
+		})
+	}
+	if interrupt != nil {
+		exited := make(chan struct{})
+		defer close(exited)
+		go func() {
+			select {
+			case <-interrupt:
+				kill()
+			case <-exited:
+			}
+		}()
+	}
+
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	output := job.mask(out.String())
+	if err != nil {
+		jobErr := &JobError{
 			Name: job.Name,
 			Path: root,
 			Err:  err,
-			out:  out.String(),
+			out:  output,
 		}
+		if timeout.Load() {
+			jobErr.Timeout = job.Timeout
+		}
+		return output, jobErr
+	}
+	return output, nil
+}
+
+// isTerminalForeground checks if the stdin is the controlling terminal and
+// the current process group is its foreground.
+func isTerminalForeground() bool {
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == syscall.Getpgrp()
+}
+
+// restoreTerminalForeground takes the terminal's foreground back from the
+// job process group. The SIGTTOU sent to the background process that does
+// this should be ignored.
+func restoreTerminalForeground() {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, syscall.Getpgrp())
+}
+
+// ResolveJobSecrets gets the secrets used by jobs, the shared secrets (from
+// workflow) are applied to all jobs and can be overridden by the job's own.
+// Every secret is only resolved once, so the password is asked once per
+// run.
+func ResolveJobSecrets(jobs []*Job, shared map[string]string) error {
+	values := make(map[string]string)
+	resolve := func(env osutil.Env, refs map[string]string) error {
+		for name, key := range refs {
+			value, ok := values[key]
+			if !ok {
+				var err error
+				value, err = GetSecret(key, false)
+				if err != nil {
+					return errors.Trace(err, "get secret for %s", name)
+				}
+				values[key] = value
+			}
+			env[name] = value
+		}
+		return nil
+	}
+	for _, job := range jobs {
+		env := make(osutil.Env, len(shared)+len(job.Secrets))
+		err := resolve(env, shared)
+		if err != nil {
+			return err
+		}
+		err = resolve(env, job.Secrets)
+		if err != nil {
+			return errors.Trace(err, "job %s", job.Name)
+		}
+		job.secrets = env
 	}
 	return nil
 }
 
+// mask replaces the secret values in s with "***", the longer values are
+// replaced first in case one value contains another.
+func (job *Job) mask(s string) string {
+	if len(job.secrets) == 0 {
+		return s
+	}
+	values := make([]string, 0, len(job.secrets))
+	for _, value := range job.secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, "***")
+	}
+	return s
+}
+
 func (job *Job) Skip(env osutil.Env) (string, bool) {
 	for _, requireKey := range job.RequireEnv {
 		var ok bool
@@ -98,42 +447,148 @@ func (job *Job) Say(msg string, args ...any) {
 }
 
 func (job *Job) Cmd(root string, env osutil.Env, out *bytes.Buffer) (*exec.Cmd, error) {
-	var args []string
-	if job.Run != "" {
-		args = []string{"-c", job.Run}
-	} else {
-		jobPath, err := GetJobPath(job.Name)
+	expand := func(s string) string { return job.expand(s, env) }
+	args, err := job.args(expand)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	setJobStdio(cmd, out)
+	root, err = job.workdir(root, env)
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		exists, err := osutil.DirExists(root)
 		if err != nil {
-			return nil, err
+			return nil, errors.Trace(err, "check job root path exists")
 		}
-		args = []string{jobPath}
+		if !exists {
+			return nil, fmt.Errorf("job root path %s does not exists", root)
+		}
+		cmd.Dir = root
+	}
+	err = job.setEnv(cmd, env, expand)
+	if err != nil {
+		return nil, err
 	}
+	return cmd, nil
+}
 
-	cmd := exec.Command("bash", args...)
+// setJobStdio writes the output to out if MuteJob, otherwise the job uses
+// the terminal.
+func setJobStdio(cmd *exec.Cmd, out *bytes.Buffer) {
 	if MuteJob {
 		if out != nil {
 			cmd.Stdout = out
 			cmd.Stderr = out
 		}
-	} else {
-		cmd.Stdout = os.Stderr
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
+		return
 	}
-	if root != "" {
-		exists, err := osutil.DirExists(root)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+}
+
+// workdir returns the directory to execute the job, the Workdir cannot be
+// outside of root.
+func (job *Job) workdir(root string, env osutil.Env) (string, error) {
+	if job.Workdir == "" {
+		return root, nil
+	}
+	dir := env.Expand(job.Workdir)
+	if !filepath.IsLocal(dir) {
+		return "", fmt.Errorf("job workdir %q is outside of the repo", dir)
+	}
+	return filepath.Join(root, dir), nil
+}
+
+// setEnv sets the env, params and secrets for the job command.
+func (job *Job) setEnv(cmd *exec.Cmd, env osutil.Env, expand func(string) string) error {
+	if len(job.Secrets) > 0 && job.secrets == nil {
+		// The job is not resolved by workflow, such as builder jobs.
+		err := ResolveJobSecrets([]*Job{job}, nil)
 		if err != nil {
-			return nil, errors.Trace(err, "check job root path exists")
+			return err
 		}
-		if !exists {
-			return nil, fmt.Errorf("job root path %s does not exists", root)
+	}
+	if job.params == nil {
+		err := job.resolveParams()
+		if err != nil {
+			return err
 		}
-		cmd.Dir = root
 	}
+	params := job.expandParams(expand)
 	if len(env) > 0 {
+		// The job only gets the given env, not the inherited one.
 		env.SetCmd(cmd)
+	} else if len(job.secrets) > 0 || len(params) > 0 {
+		// Setting cmd.Env drops the inherited env, keep it.
+		cmd.Env = os.Environ()
 	}
-	return cmd, nil
+	params.SetCmd(cmd)
+	job.secrets.SetCmd(cmd)
+	return nil
+}
+
+// args returns the command to execute, the "$VAR" in Command are replaced
+// by expand.
+func (job *Job) args(expand func(string) string) ([]string, error) {
+	if len(job.Command) > 0 {
+		args := make([]string, len(job.Command))
+		for i, arg := range job.Command {
+			args[i] = expand(arg)
+		}
+		return args, nil
+	}
+
+	shell := strings.Fields(job.Shell)
+	if job.Run != "" {
+		if len(shell) == 0 {
+			shell = []string{"bash"}
+		}
+		flag, ok := inlineScriptFlags[filepath.Base(shell[0])]
+		if !ok {
+			flag = "-c"
+		}
+		return append(shell, flag, job.Run), nil
+	}
+
+	jobPath, err := GetJobPath(job.file())
+	if err != nil {
+		return nil, err
+	}
+	if len(shell) == 0 {
+		if interpreter := JobFileShell(jobPath); interpreter != "" {
+			shell = []string{interpreter}
+		}
+	}
+	return append(shell, jobPath), nil
+}
+
+// expandParams returns the params env of job file, the "$VAR" in values
+// are replaced by expand.
+func (job *Job) expandParams(expand func(string) string) osutil.Env {
+	params := make(osutil.Env, len(job.params))
+	for key, value := range job.params {
+		params[key] = expand(value)
+	}
+	return params
+}
+
+// expand replaces the "$VAR" in s with job env and secrets, fallback to
+// system env.
+func (job *Job) expand(s string, env osutil.Env) string {
+	return os.Expand(s, func(key string) string {
+		if value, ok := env[key]; ok {
+			return value
+		}
+		if value, ok := job.secrets[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	})
 }
 
 func wrapJobCmdError(err error) error {
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/expr"
+	"github.com/fioncat/gitzombie/pkg/git"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// JobStatus is the status of a job executed in a repo. The later jobs can
+// refer the result (success, failure or skipped) by "result(name)" in their
+// "if" expressions.
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+
+	JobSuccess JobStatus = "success"
+	JobFailure JobStatus = "failure"
+	JobSkipped JobStatus = "skipped"
+)
+
+// jobContext is the context to execute jobs in one repo, it provides the
+// variables and functions for the "if" expression:
+//
+//	branch              the current branch of repo
+//	repo, group, remote the repo info, empty if the path is not a repo
+//	env.NAME            the job env, fallback to system env
+//	exists(pattern...)  whether any file in repo matches the glob patterns
+//	result(job)         the result of previous job: success, failure, skipped
+type jobContext struct {
+	root string
+	env  osutil.Env
+
+	// The jobs might be executed concurrently, see JobRun.
+	mu      sync.Mutex
+	results map[string]JobStatus
+	reports map[*Job]*JobReport
+
+	branchOnce sync.Once
+	branch     string
+	branchErr  error
+
+	onStatus func(job *Job, status JobStatus)
+
+	// interrupt stops the retry waiting and kills the running jobs.
+	interrupt context.Context
+}
+
+func newJobContext(root string, env osutil.Env) *jobContext {
+	return &jobContext{
+		root:    root,
+		env:     env,
+		results: make(map[string]JobStatus),
+		reports: make(map[*Job]*JobReport),
+
+		interrupt: context.Background(),
+	}
+}
+
+// wait sleeps for d, returns false if interrupted.
+func (ctx *jobContext) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.interrupt.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// run executes the job and records its report.
+func (ctx *jobContext) run(job *Job) error {
+	report, err := job.run(ctx)
+	ctx.mu.Lock()
+	ctx.reports[job] = report
+	ctx.mu.Unlock()
+	ctx.report(job, report.Status)
+	return err
+}
+
+// report records the status of job, the pending and running status are
+// not results.
+func (ctx *jobContext) report(job *Job, status JobStatus) {
+	if status != JobPending && status != JobRunning {
+		ctx.mu.Lock()
+		ctx.results[job.Name] = status
+		ctx.mu.Unlock()
+	}
+	if ctx.onStatus != nil {
+		ctx.onStatus(job, status)
+	}
+}
+
+func (ctx *jobContext) result(name string) (JobStatus, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	status, ok := ctx.results[name]
+	return status, ok
+}
+
+func (ctx *jobContext) Var(name string) (any, error) {
+	if key, ok := strings.CutPrefix(name, "env."); ok {
+		if value, ok := ctx.env[key]; ok {
+			return value, nil
+		}
+		return os.Getenv(key), nil
+	}
+	switch name {
+	case "branch":
+		ctx.branchOnce.Do(func() {
+			ctx.branch, ctx.branchErr = git.GetCurrentBranch(&git.Options{
+				QuietCmd:    true,
+				QuietStderr: true,
+				Path:        ctx.root,
+			})
+		})
+		return ctx.branch, ctx.branchErr
+
+	case "repo":
+		return ctx.env["REPO_NAME"], nil
+
+	case "group":
+		return ctx.env["REPO_GROUP"], nil
+
+	case "remote":
+		return ctx.env["REPO_REMOTE"], nil
+	}
+	return nil, fmt.Errorf("unknown variable %q", name)
+}
+
+func (ctx *jobContext) Call(name string, args []any) (any, error) {
+	switch name {
+	case "exists":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("exists requires at least one pattern")
+		}
+		for _, arg := range args {
+			pattern := filepath.Join(ctx.root, fmt.Sprint(arg))
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q", arg)
+			}
+			if len(matches) > 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "result":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("result requires one job name")
+		}
+		name := fmt.Sprint(args[0])
+		result, ok := ctx.result(name)
+		if !ok {
+			return nil, fmt.Errorf("job %q has not been executed", name)
+		}
+		return string(result), nil
+	}
+	return nil, fmt.Errorf("unknown function %q", name)
+}
+
+// condition returns the parsed "if" expression, nil means the job is always
+// executed.
+func (job *Job) condition() (*expr.Expr, error) {
+	if job.cond != nil || job.If == "" {
+		return job.cond, nil
+	}
+	return expr.Parse(job.If)
+}
+
+// validateJobs checks the needs and params, parses the "if" expressions of
+// jobs, so that the errors can be reported before running.
+func validateJobs(jobs []*Job) error {
+	for _, job := range jobs {
+		if job.Name == "" {
+			job.Name = job.Uses
+		}
+	}
+	err := validateNeeds(jobs)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		err = job.resolveParams()
+		if err != nil {
+			return errors.Trace(err, "job %s", job.Name)
+		}
+		if job.If == "" {
+			continue
+		}
+		cond, err := expr.Parse(job.If)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", job.Name, err)
+		}
+		job.cond = cond
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"github.com/iancoleman/strcase"
+)
+
+// JobFile is a reusable job in "jobs" dir, it declares the description and
+// params in the comment header:
+//
+//	#!/bin/bash
+//	# @desc Run go tests
+//	# @param race=false Enable the race detector
+//	# @param pkg The packages to test
+//
+// The param without default value is required. Workflow passes the params
+// by "with", they are exported to the job as "PARAM_{NAME}" env.
+type JobFile struct {
+	Name string
+	Path string
+
+	Description string
+
+	Params []*JobParam
+}
+
+type JobParam struct {
+	Name        string
+	Description string
+
+	Default  string
+	Required bool
+}
+
+// The comment prefixes of the header, for shell, python, javascript, lua,
+// etc.
+var jobHeaderComments = []string{"#", "//", "--", ";"}
+
+func GetJobFile(name string) (*JobFile, error) {
+	path, err := GetJobPath(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Trace(err, "open job file")
+	}
+	defer file.Close()
+
+	jobFile := &JobFile{Name: name, Path: path}
+	err = jobFile.parseHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse header of job %s: %v", name, err)
+	}
+	return jobFile, nil
+}
+
+func (f *JobFile) parseHeader(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var desc []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		var comment bool
+		for _, prefix := range jobHeaderComments {
+			if content, ok := strings.CutPrefix(line, prefix); ok {
+				line = strings.TrimSpace(strings.TrimLeft(content, prefix))
+				comment = true
+				break
+			}
+		}
+		if !comment {
+			// The header ends at the first code line.
+			break
+		}
+
+		tag, content, _ := strings.Cut(line, " ")
+		content = strings.TrimSpace(content)
+		switch tag {
+		case "@desc":
+			desc = append(desc, content)
+
+		case "@param":
+			spec, paramDesc, _ := strings.Cut(content, " ")
+			name, value, hasDefault := strings.Cut(spec, "=")
+			if name == "" {
+				return fmt.Errorf("missing param name in %q", line)
+			}
+			if f.GetParam(name) != nil {
+				return fmt.Errorf("duplicate param %q", name)
+			}
+			f.Params = append(f.Params, &JobParam{
+				Name:        name,
+				Description: strings.TrimSpace(paramDesc),
+				Default:     value,
+				Required:    !hasDefault,
+			})
+		}
+	}
+	f.Description = strings.Join(desc, " ")
+	return scanner.Err()
+}
+
+func (f *JobFile) GetParam(name string) *JobParam {
+	for _, param := range f.Params {
+		if param.Name == name {
+			return param
+		}
+	}
+	return nil
+}
+
+// ResolveParams checks the params passed by "with", and converts them to
+// env, the missing params use default values.
+func (f *JobFile) ResolveParams(with map[string]string) (osutil.Env, error) {
+	for name := range with {
+		if f.GetParam(name) == nil {
+			return nil, fmt.Errorf("unknown param %q", name)
+		}
+	}
+	env := make(osutil.Env, len(f.Params))
+	for _, param := range f.Params {
+		value, ok := with[param.Name]
+		if !ok {
+			if param.Required {
+				return nil, fmt.Errorf("missing required param %q", param.Name)
+			}
+			value = param.Default
+		}
+		env[param.EnvName()] = value
+	}
+	return env, nil
+}
+
+// EnvName returns the env name of param, such as "PARAM_RACE" for "race".
+func (p *JobParam) EnvName() string {
+	return "PARAM_" + strcase.ToScreamingSnake(p.Name)
+}
+
+// file returns the name of job file to execute, empty if the job is inline
+// (Run or Command).
+func (job *Job) file() string {
+	if job.Run != "" || len(job.Command) > 0 {
+		return ""
+	}
+	if job.Uses != "" {
+		return job.Uses
+	}
+	return job.Name
+}
+
+// resolveParams loads the params of job file with "with".
+func (job *Job) resolveParams() error {
+	name := job.file()
+	if name == "" {
+		return nil
+	}
+	jobFile, err := GetJobFile(name)
+	if err != nil {
+		return err
+	}
+	job.params, err = jobFile.ResolveParams(job.With)
+	return err
+}
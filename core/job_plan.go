@@ -0,0 +1,123 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// JobPlan describes how a job would be executed in a repo, see
+// JobRun.Plan.
+type JobPlan struct {
+	Name string
+
+	// Skip is the reason why the job would be skipped, empty means the job
+	// would run.
+	Skip string
+	// Error is not empty if the job cannot be executed, such as the job file
+	// is missing.
+	Error string
+	// Pending is the "if" expression that cannot be evaluated before the
+	// repo is cloned, see WorkflowSelect.RemoteRepos.
+	Pending string
+
+	Dir string
+	// Args is the command to execute. The Run script is kept as it is, only
+	// the job env in Command is expanded, see expandJobEnv.
+	Args []string
+	// Params are the params env of job file, the job env in values is
+	// expanded.
+	Params osutil.Env
+}
+
+// Plan resolves the jobs without executing them, it is used by dry-run. The
+// "if" expressions are evaluated as if all the previous jobs that would run
+// succeeded. If Root is empty (the repo is not cloned yet), the "if"
+// expressions are not evaluated and recorded in JobPlan.Pending.
+func (r *JobRun) Plan() []*JobPlan {
+	ctx := newJobContext(r.Root, r.Env)
+	plans := make([]*JobPlan, len(r.Jobs))
+	for i, job := range r.Jobs {
+		plan := job.plan(ctx)
+		status := JobSuccess
+		switch {
+		case plan.Skip != "":
+			status = JobSkipped
+
+		case plan.Error != "":
+			status = JobFailure
+		}
+		ctx.report(job, status)
+		plans[i] = plan
+	}
+	return plans
+}
+
+func (job *Job) plan(ctx *jobContext) *JobPlan {
+	plan := &JobPlan{Name: job.Name, Dir: ctx.root}
+	if key, skip := job.Skip(ctx.env); skip {
+		plan.Skip = fmt.Sprintf("unbound env %q", key)
+		return plan
+	}
+	cond, err := job.condition()
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	if cond != nil && ctx.root == "" {
+		plan.Pending = cond.String()
+	} else if cond != nil {
+		ok, err := cond.Eval(ctx)
+		if err != nil {
+			plan.Error = fmt.Sprintf("evaluate if %s: %v", cond, err)
+			return plan
+		}
+		if !ok {
+			plan.Skip = fmt.Sprintf("if %s is false", cond)
+			return plan
+		}
+	}
+
+	dir, err := job.workdir(plan.Dir, ctx.env)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	plan.Dir = dir
+	expand := func(s string) string { return expandJobEnv(s, ctx.env) }
+	args, err := job.args(expand)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	if job.params == nil {
+		err = job.resolveParams()
+		if err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+	}
+	if len(job.params) > 0 {
+		plan.Params = job.expandParams(expand)
+	}
+	plan.Args = args
+	return plan
+}
+
+var jobEnvRegex = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+
+// expandJobEnv replaces the "$VAR" and "${VAR}" in s with job env. Unlike
+// Job.expand, the system env and secrets are not used, and the unknown
+// variables (such as "$1" in script) are kept, so that the plan can be
+// shared without leaking anything.
+func expandJobEnv(s string, env osutil.Env) string {
+	return jobEnvRegex.ReplaceAllStringFunc(s, func(match string) string {
+		key := strings.Trim(match, "${}")
+		if value, ok := env[key]; ok {
+			return value
+		}
+		return match
+	})
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// jobSlots limits the number of jobs running at the same time across all
+// repos, nil means no limit.
+var jobSlots chan struct{}
+
+// SetJobConcurrency sets the max number of jobs running at the same time,
+// zero means no limit.
+func SetJobConcurrency(n int) {
+	if n <= 0 {
+		jobSlots = nil
+		return
+	}
+	jobSlots = make(chan struct{}, n)
+}
+
+func acquireJobSlot() {
+	if jobSlots != nil {
+		jobSlots <- struct{}{}
+	}
+}
+
+func releaseJobSlot() {
+	if jobSlots != nil {
+		<-jobSlots
+	}
+}
+
+// JobRun executes jobs in one repo. If no job has needs, the jobs are
+// executed in order. Otherwise, the jobs are executed concurrently once
+// their needs are finished. Both stop at the first failed job unless it has
+// ContinueOnError.
+type JobRun struct {
+	Jobs []*Job
+	Root string
+	Env  osutil.Env
+
+	// OnStatus is called when the status of a job is changed, it might be
+	// called concurrently.
+	OnStatus func(job *Job, status JobStatus)
+
+	// Context interrupts the jobs when it is done: the running jobs are
+	// killed and the failed jobs are not retried. Default is never done.
+	Context context.Context
+
+	// Reports are the execution records of jobs, in the same order as Jobs.
+	// Available after Execute.
+	Reports []*JobReport
+}
+
+// JobReport records the execution of a job in one repo.
+type JobReport struct {
+	Name     string        `json:"name"`
+	Status   JobStatus     `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	Attempts int           `json:"attempts,omitempty"`
+	ExitCode int           `json:"exit_code"`
+
+	// Output is only captured when MuteJob, the secrets are masked.
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func ExecuteJobs(jobs []*Job, root string, env osutil.Env) error {
+	run := &JobRun{Jobs: jobs, Root: root, Env: env}
+	return run.Execute()
+}
+
+func (r *JobRun) Execute() error {
+	ctx := newJobContext(r.Root, r.Env)
+	ctx.onStatus = r.OnStatus
+	if r.Context != nil {
+		ctx.interrupt = r.Context
+	}
+	for _, job := range r.Jobs {
+		ctx.report(job, JobPending)
+	}
+
+	var err error
+	if hasNeeds(r.Jobs) {
+		err = r.executeGraph(ctx)
+	} else {
+		err = r.executeInOrder(ctx)
+	}
+
+	r.Reports = make([]*JobReport, len(r.Jobs))
+	for i, job := range r.Jobs {
+		report := ctx.reports[job]
+		if report == nil {
+			// The jobs not executed because of failure are marked as
+			// skipped.
+			report = &JobReport{Name: job.Name, Status: JobSkipped}
+			ctx.report(job, JobSkipped)
+		}
+		r.Reports[i] = report
+	}
+	return err
+}
+
+func (r *JobRun) executeInOrder(ctx *jobContext) error {
+	for _, job := range r.Jobs {
+		err := ctx.run(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jobDone struct {
+	job *Job
+	err error
+}
+
+func (r *JobRun) executeGraph(ctx *jobContext) error {
+	waiting := make(map[string]int, len(r.Jobs))
+	dependents := make(map[string][]*Job, len(r.Jobs))
+	var ready []*Job
+	for _, job := range r.Jobs {
+		waiting[job.Name] = len(job.Needs)
+		for _, need := range job.Needs {
+			dependents[need] = append(dependents[need], job)
+		}
+		if len(job.Needs) == 0 {
+			ready = append(ready, job)
+		}
+	}
+
+	// The output of jobs is written to terminal if not muted, so they
+	// cannot be executed concurrently.
+	parallel := len(r.Jobs)
+	if !MuteJob {
+		parallel = 1
+	}
+
+	doneCh := make(chan *jobDone)
+	var running int
+	var firstErr error
+	for {
+		for firstErr == nil && len(ready) > 0 && running < parallel {
+			job := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				err := ctx.run(job)
+				doneCh <- &jobDone{job: job, err: err}
+			}()
+		}
+		if running == 0 {
+			return firstErr
+		}
+
+		done := <-doneCh
+		running--
+		if done.err != nil {
+			// Stop scheduling new jobs, wait for the running ones.
+			if firstErr == nil {
+				firstErr = done.err
+			}
+			continue
+		}
+		for _, job := range dependents[done.job.Name] {
+			waiting[job.Name]--
+			if waiting[job.Name] == 0 {
+				ready = append(ready, job)
+			}
+		}
+	}
+}
+
+func hasNeeds(jobs []*Job) bool {
+	for _, job := range jobs {
+		if len(job.Needs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNeeds makes sure that the needs of jobs are existing and have no
+// cycle.
+func validateNeeds(jobs []*Job) error {
+	if !hasNeeds(jobs) {
+		return nil
+	}
+	byName := make(map[string]*Job, len(jobs))
+	for _, job := range jobs {
+		if _, ok := byName[job.Name]; ok {
+			return fmt.Errorf("duplicate job name %q, the name must be unique when using needs", job.Name)
+		}
+		byName[job.Name] = job
+	}
+	for _, job := range jobs {
+		for _, need := range job.Needs {
+			if _, ok := byName[need]; !ok {
+				return fmt.Errorf("job %s needs unknown job %q", job.Name, need)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(jobs))
+	var path []string
+	var visit func(job *Job) error
+	visit = func(job *Job) error {
+		switch state[job.Name] {
+		case visited:
+			return nil
+
+		case visiting:
+			var start int
+			for i, name := range path {
+				if name == job.Name {
+					start = i
+					break
+				}
+			}
+			cycle := append(path[start:], job.Name)
+			return fmt.Errorf("found cycle in job needs: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[job.Name] = visiting
+		path = append(path, job.Name)
+		for _, need := range job.Needs {
+			err := visit(byName[need])
+			if err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[job.Name] = visited
+		return nil
+	}
+	for _, job := range jobs {
+		err := visit(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
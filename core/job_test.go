@@ -0,0 +1,506 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"gopkg.in/yaml.v3"
+)
+
+func TestJobSecrets(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	job := &Job{
+		Name:    "publish",
+		Run:     `echo "token=$NPM_TOKEN"; echo "$TOKEN_PREFIX"; exit 1`,
+		Secrets: map[string]string{"NPM_TOKEN": "npm_token", "TOKEN_PREFIX": "npm_prefix"},
+		secrets: osutil.Env{
+			"NPM_TOKEN":    "abc123456",
+			"TOKEN_PREFIX": "abc",
+		},
+	}
+	err := job.Execute(t.TempDir(), nil)
+	jobErr, ok := err.(*JobError)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := jobErr.Out()
+	if strings.Contains(out, "abc") {
+		t.Fatalf("secret is not masked: %q", out)
+	}
+	if out != "token=***\n***\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// The secrets are expanded in command args, the job with env only gets
+	// the env and secrets.
+	t.Setenv("JOB_SECRETS_TEST", "inherited")
+	job = &Job{
+		Name:    "login",
+		Command: []string{"sh", "-c", `echo "--token=$NPM_TOKEN"; printenv JOB_SECRETS_TEST; exit 1`},
+		Secrets: map[string]string{"NPM_TOKEN": "npm_token"},
+		secrets: osutil.Env{"NPM_TOKEN": "abc123456"},
+	}
+	err = job.Execute(t.TempDir(), osutil.Env{"REPO_NAME": "test"})
+	jobErr, ok = err.(*JobError)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out = jobErr.Out(); out != "--token=***\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	var job Job
+	err := yaml.Unmarshal([]byte(`
+name: sleep
+run: "echo start; sleep 10 & sleep 10"
+timeout: 200ms
+retries: 1
+retry_delay: 10ms
+`), &job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = job.Execute(t.TempDir(), nil)
+	if time.Since(start) > time.Second*5 {
+		t.Fatal("the job is not killed by timeout")
+	}
+	jobErr, ok := err.(*JobError)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if jobErr.Attempts != 2 || jobErr.Timeout != time.Millisecond*200 {
+		t.Fatalf("unexpected job error %+v", jobErr)
+	}
+	if jobErr.Out() != "start\n" {
+		t.Fatalf("unexpected output %q", jobErr.Out())
+	}
+
+	job.ContinueOnError = true
+	err = job.Execute(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("error should be ignored: %v", err)
+	}
+
+	// The job waiting for retry does not hold the slot.
+	SetJobConcurrency(1)
+	defer SetJobConcurrency(0)
+	retry := &Job{Name: "retry", Run: "exit 1", Retries: 1, RetryDelay: time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		retry.Execute(t.TempDir(), nil)
+	}()
+	time.Sleep(time.Millisecond * 300)
+	start = time.Now()
+	err = (&Job{Name: "quick", Run: "true"}).Execute(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Fatal("the slot is held during retry delay")
+	}
+	<-done
+}
+
+func TestJobTimeoutGroup(t *testing.T) {
+	// The job not muted is also killed with its children by timeout.
+	root := t.TempDir()
+	job := &Job{
+		Name:    "group",
+		Run:     "(sleep 1; touch late) & sleep 10",
+		Timeout: time.Millisecond * 200,
+	}
+	err := job.Execute(root, nil)
+	if jobErr, ok := err.(*JobError); !ok || jobErr.Timeout == 0 {
+		t.Fatalf("expect timeout error, found %v", err)
+	}
+	time.Sleep(time.Millisecond * 1500)
+	exists, err := osutil.FileExists(filepath.Join(root, "late"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("the children of job are not killed by timeout")
+	}
+}
+
+func TestJobInterrupt(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*300, cancel)
+	run := &JobRun{
+		Jobs: []*Job{
+			{Name: "retry", Run: "exit 1", Retries: 3, RetryDelay: time.Minute},
+			{Name: "next", Run: "true"},
+		},
+		Root:    t.TempDir(),
+		Context: ctx,
+	}
+	start := time.Now()
+	err := run.Execute()
+	if time.Since(start) > time.Second*5 {
+		t.Fatal("the retry delay is not interrupted")
+	}
+	jobErr, ok := err.(*JobError)
+	if !ok || jobErr.Attempts != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The running job is killed.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*300, cancel)
+	run = &JobRun{
+		Jobs:    []*Job{{Name: "sleep", Run: "sleep 10", Timeout: time.Minute}},
+		Root:    t.TempDir(),
+		Context: ctx,
+	}
+	start = time.Now()
+	err = run.Execute()
+	if time.Since(start) > time.Second*5 {
+		t.Fatal("the running job is not killed by interrupt")
+	}
+	if _, ok = err.(*JobError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestJobCondition(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "go.mod"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wf Workflow
+	err = yaml.Unmarshal([]byte(`
+jobs:
+  - name: go
+    if: exists("go.mod", "*.go")
+    run: touch go.done
+  - name: node
+    if: exists("package.json")
+    run: touch node.done
+  - name: lint
+    if: remote == "github" && env.LINT != "false"
+    run: exit 1
+    continue_on_error: true
+  - name: report
+    if: result("lint") == "failure" && result("node") == "skipped"
+    run: touch report.done
+`), &wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ExecuteJobs(wf.Jobs, root, osutil.Env{"REPO_REMOTE": "github"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]bool{
+		"go.done":     true,
+		"node.done":   false,
+		"report.done": true,
+	} {
+		_, err = os.Stat(filepath.Join(root, name))
+		if exists := err == nil; exists != expect {
+			t.Fatalf("%s: expect exists %v", name, expect)
+		}
+	}
+
+	wf.Jobs[0].If = `exists("go.mod"`
+	if wf.Validate() == nil {
+		t.Fatal("expect syntax error")
+	}
+}
+
+func TestJobShell(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobsDir := config.GetDir("jobs")
+	err = osutil.WriteFile(filepath.Join(jobsDir, "hello.zsh"), []byte("echo hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".hidden", "hello.zsh.bak", "deploy", "lint.ts"} {
+		err = osutil.WriteFile(filepath.Join(jobsDir, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := ListJobNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"deploy", "hello", "hello.zsh.bak", "lint.ts"}) {
+		t.Fatalf("unexpected job names %v", names)
+	}
+
+	root := t.TempDir()
+	err = os.Mkdir(filepath.Join(root, "web"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := osutil.Env{"REPO_NAME": "fioncat/gitzombie"}
+	cases := []struct {
+		job    *Job
+		expect []string
+	}{
+		{
+			job:    &Job{Name: "hello"},
+			expect: []string{"zsh", filepath.Join(jobsDir, "hello.zsh")},
+		},
+		{
+			job:    &Job{Name: "deploy"},
+			expect: []string{filepath.Join(jobsDir, "deploy")},
+		},
+		{
+			job:    &Job{Name: "hello", Shell: "sh -e"},
+			expect: []string{"sh", "-e", filepath.Join(jobsDir, "hello.zsh")},
+		},
+		{
+			job:    &Job{Name: "node", Shell: "node", Run: "console.log(1)"},
+			expect: []string{"node", "-e", "console.log(1)"},
+		},
+		{
+			job:    &Job{Name: "py", Shell: "/usr/bin/python3", Run: "print(1)"},
+			expect: []string{"/usr/bin/python3", "-c", "print(1)"},
+		},
+		{
+			job:    &Job{Name: "pwd", Command: []string{"pwd", "$REPO_NAME"}, Workdir: "web"},
+			expect: []string{"pwd", "fioncat/gitzombie"},
+		},
+	}
+	for _, c := range cases {
+		cmd, err := c.job.Cmd(root, env, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cmd.Args, c.expect) {
+			t.Fatalf("%s: unexpected args %v", c.job.Name, cmd.Args)
+		}
+	}
+
+}
+
+func TestJobWorkdir(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	root := t.TempDir()
+	err := os.Mkdir(filepath.Join(root, "web"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	job := &Job{Name: "pwd", Command: []string{"pwd"}, Workdir: "web"}
+	cmd, err := job.Cmd(root, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out.String()) != filepath.Join(root, "web") {
+		t.Fatalf("unexpected workdir %q", out.String())
+	}
+
+	for _, workdir := range []string{"..", "web/../..", "/tmp", "$OUT"} {
+		job = &Job{Name: "pwd", Command: []string{"pwd"}, Workdir: workdir}
+		_, err = job.Cmd(root, osutil.Env{"OUT": "../web"}, nil)
+		if err == nil {
+			t.Fatalf("%s: expect workdir outside error", workdir)
+		}
+	}
+}
+
+func TestJobNeeds(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	var wf Workflow
+	err := yaml.Unmarshal([]byte(`
+jobs:
+  - name: lint
+    run: sleep 0.3
+  - name: test
+    run: sleep 0.3
+  - name: vet
+    run: sleep 0.3
+  - name: build
+    needs: [lint, test, vet]
+    if: result("lint") == "success" && result("vet") == "success"
+    run: touch build.done
+`), &wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(concurrency int) (time.Duration, map[string][]JobStatus) {
+		SetJobConcurrency(concurrency)
+		defer SetJobConcurrency(0)
+
+		var mu sync.Mutex
+		history := make(map[string][]JobStatus)
+		root := t.TempDir()
+		start := time.Now()
+		err := (&JobRun{
+			Jobs: wf.Jobs,
+			Root: root,
+			OnStatus: func(job *Job, status JobStatus) {
+				mu.Lock()
+				defer mu.Unlock()
+				history[job.Name] = append(history[job.Name], status)
+			},
+		}).Execute()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(filepath.Join(root, "build.done"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start), history
+	}
+
+	elapsed, history := run(0)
+	if elapsed > time.Millisecond*800 {
+		t.Fatalf("jobs are not executed concurrently, took %v", elapsed)
+	}
+	expect := []JobStatus{JobPending, JobRunning, JobSuccess}
+	for _, job := range wf.Jobs {
+		if !reflect.DeepEqual(history[job.Name], expect) {
+			t.Fatalf("unexpected status of %s: %v", job.Name, history[job.Name])
+		}
+	}
+
+	elapsed, _ = run(1)
+	if elapsed < time.Millisecond*900 {
+		t.Fatalf("concurrency limit is not respected, took %v", elapsed)
+	}
+}
+
+func TestJobNeedsValidate(t *testing.T) {
+	for _, jobs := range [][]*Job{
+		{
+			{Name: "a", Needs: []string{"b"}},
+			{Name: "b", Needs: []string{"c"}},
+			{Name: "c", Needs: []string{"a"}},
+		},
+		{
+			{Name: "a", Needs: []string{"a"}},
+		},
+		{
+			{Name: "a", Needs: []string{"unknown"}},
+		},
+		{
+			{Name: "a"},
+			{Name: "a", Needs: []string{"a"}},
+		},
+	} {
+		err := validateJobs(jobs)
+		if err == nil {
+			t.Fatal("expect validate error")
+		}
+		t.Log(err)
+	}
+}
+
+func TestJobParams(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobsDir := config.GetDir("jobs")
+	err = osutil.WriteFile(filepath.Join(jobsDir, "go-test.sh"), []byte(`#!/bin/bash
+# @desc Run go tests
+# @param race=false Enable the race detector
+# @param pkg The packages to test
+echo "race=$PARAM_RACE pkg=$PARAM_PKG"
+# @param ignored=true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobFile, err := GetJobFile("go-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*JobParam{
+		{Name: "race", Description: "Enable the race detector", Default: "false"},
+		{Name: "pkg", Description: "The packages to test", Required: true},
+	}
+	if jobFile.Description != "Run go tests" || !reflect.DeepEqual(jobFile.Params, expect) {
+		t.Fatalf("unexpected job file %+v", jobFile)
+	}
+
+	jobs := []*Job{{Uses: "go-test", With: map[string]string{"pkg": "${REPO_NAME}/..."}}}
+	err = validateJobs(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Name != "go-test" {
+		t.Fatalf("unexpected job name %q", jobs[0].Name)
+	}
+	run := &JobRun{Jobs: jobs, Env: osutil.Env{"REPO_NAME": "gitzombie"}}
+	err = run.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := run.Reports[0].Output; out != "race=false pkg=gitzombie/...\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	for _, with := range []map[string]string{
+		{},
+		{"pkg": "./...", "unknown": "value"},
+	} {
+		err = validateJobs([]*Job{{Uses: "go-test", With: with}})
+		if err == nil {
+			t.Fatalf("expect error for %v", with)
+		}
+	}
+}
@@ -16,10 +16,13 @@ type Remote struct {
 	User  string `toml:"user" validate:"required"`
 	Email string `toml:"email" validate:"email"`
 
-	Provider    string `toml:"provider" validate:"enum_provider"`
-	Token       string `toml:"token"`
-	TokenSecret bool   `toml:"token_secret"`
-	API         string `toml:"api" validate:"omitempty,uri"`
+	Provider        string `toml:"provider" validate:"enum_provider"`
+	Token           string `toml:"token"`
+	TokenSecret     bool   `toml:"token_secret"`
+	TokenCmd        string `toml:"token_cmd"`
+	TokenCredential bool   `toml:"token_credential"`
+	API             string `toml:"api" validate:"omitempty,uri"`
+	Timeout         int    `toml:"timeout" validate:"gte=0"`
 
 	Groups []*RemoteGroup `toml:"groups" validate:"unique=Name,dive"`
 }
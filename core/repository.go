@@ -36,6 +36,9 @@ type Repository struct {
 	LastAccess int64
 	Access     uint64
 
+	// Labels are used to select repos in workflow.
+	Labels []string
+
 	workspace bool
 
 	group string
@@ -92,6 +95,35 @@ func (repo *Repository) normalize() error {
 	return nil
 }
 
+func (repo *Repository) HasLabel(label string) bool {
+	for _, l := range repo.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLabels adds or removes labels, the labels are kept sorted.
+func (repo *Repository) SetLabels(labels []string, remove bool) {
+	set := make(map[string]struct{}, len(repo.Labels)+len(labels))
+	for _, label := range repo.Labels {
+		set[label] = struct{}{}
+	}
+	for _, label := range labels {
+		if remove {
+			delete(set, label)
+			continue
+		}
+		set[label] = struct{}{}
+	}
+	repo.Labels = make([]string, 0, len(set))
+	for label := range set {
+		repo.Labels = append(repo.Labels, label)
+	}
+	sort.Strings(repo.Labels)
+}
+
 func (repo *Repository) FullName() string {
 	return fmt.Sprintf("%s:%s", repo.Remote, repo.Name)
 }
@@ -562,10 +594,18 @@ func (err *parseRepositoryStorageError) Extra() {
 }
 
 func ConvertToGroups(repos []*Repository) []string {
+	names := make([]string, len(repos))
+	for i, repo := range repos {
+		names[i] = repo.Name
+	}
+	return ConvertNamesToGroups(names)
+}
+
+func ConvertNamesToGroups(names []string) []string {
 	groups := make([]string, 0)
 	groupMap := make(map[string]struct{})
-	for _, repo := range repos {
-		group, _ := SplitGroup(repo.Name)
+	for _, name := range names {
+		group, _ := SplitGroup(name)
 		if _, ok := groupMap[group]; ok {
 			continue
 		}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/fioncat/gitzombie/config"
 	"github.com/fioncat/gitzombie/pkg/crypto"
@@ -17,79 +19,271 @@ type Secrets map[string]*Secret
 type Secret struct {
 	Value string `yaml:"value"`
 	Salt  string `yaml:"salt"`
+
+	// The key derivation parameters, empty means the legacy sha256, which
+	// should be upgraded by "gz secret migrate".
+	KDF *crypto.KDF `yaml:"kdf,omitempty"`
+}
+
+// The secrets file without version field is version 1, which is a map of
+// secrets and uses sha256 to derive key.
+const secretsVersion = 2
+
+type secretsFile struct {
+	Version int `yaml:"version"`
+
+	// Master is not empty in master-password mode, all the secrets are
+	// encrypted by one master password. It encrypts masterCheck to verify
+	// the password.
+	Master *Secret `yaml:"master,omitempty"`
+
+	Secrets Secrets `yaml:"secrets"`
 }
 
-func readSecrets() (Secrets, error) {
+const masterCheck = "gitzombie"
+
+// The key to cache master password in agent, it cannot conflict with user
+// secret keys because of the NUL byte.
+const agentMasterKey = "\x00master"
+
+func readSecrets() (*secretsFile, error) {
 	path := config.GetDir("secrets")
 	exists, err := osutil.FileExists(path)
 	if err != nil {
 		return nil, errors.Trace(err, "check secrets file exists")
 	}
 	if !exists {
-		return Secrets{}, nil
+		return &secretsFile{
+			Version: secretsVersion,
+			Secrets: Secrets{},
+		}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err, "read secrets file")
 	}
 
-	file, err := os.Open(path)
+	var root yaml.Node
+	err = yaml.Unmarshal(data, &root)
 	if err != nil {
-		return nil, errors.Trace(err, "open secrets file")
+		return nil, errors.Trace(err, "parse secrets yaml")
 	}
-	defer file.Close()
 
-	s := Secrets{}
-	err = yaml.NewDecoder(file).Decode(&s)
+	file := &secretsFile{Version: 1}
+	if isVersionedSecrets(&root) {
+		err = root.Decode(file)
+	} else {
+		err = root.Decode(&file.Secrets)
+	}
 	if err != nil {
 		return nil, errors.Trace(err, "parse secrets yaml")
 	}
+	if file.Version > secretsVersion {
+		return nil, fmt.Errorf("unsupported secrets file version %d, please upgrade gitzombie", file.Version)
+	}
+	if file.Secrets == nil {
+		file.Secrets = Secrets{}
+	}
+	return file, nil
+}
 
-	return s, nil
+// isVersionedSecrets checks if there is a scalar "version" field in the
+// secrets file. The legacy file might have a secret named "version", but its
+// value is a mapping.
+func isVersionedSecrets(root *yaml.Node) bool {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return false
+	}
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "version" {
+			return node.Content[i+1].Kind == yaml.ScalarNode
+		}
+	}
+	return false
 }
 
-func writeSecrets(s Secrets) error {
+func writeSecrets(file *secretsFile) error {
+	// Always write the latest version, the legacy secrets are still readable
+	// because they have no kdf parameters.
+	file.Version = secretsVersion
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(file)
+	if err != nil {
+		return errors.Trace(err, "encode secrets yaml")
+	}
+
 	path := config.GetDir("secrets")
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	err = os.WriteFile(path, buf.Bytes(), 0600)
 	if err != nil {
-		return errors.Trace(err, "open secrets file")
+		return errors.Trace(err, "write secrets file")
 	}
-	defer file.Close()
+	// The file might be created by old version with 0644.
+	return errors.Trace(os.Chmod(path, 0600), "chmod secrets file")
+}
 
-	encoder := yaml.NewEncoder(file)
-	encoder.SetIndent(2)
-	err = encoder.Encode(s)
-	return errors.Trace(err, "encode secrets yaml")
+func (file *secretsFile) masterPassword() (string, error) {
+	if password, ok := agentGetSecret(agentMasterKey, file.Master); ok {
+		return password, nil
+	}
+	password, err := term.InputPassword("Please input master password")
+	if err != nil {
+		return "", err
+	}
+	check, err := file.Master.decrypt(password)
+	if err != nil {
+		return "", err
+	}
+	if check != masterCheck {
+		return "", crypto.ErrIncorrectPassword
+	}
+	agentSetSecret(agentMasterKey, file.Master, password)
+	return password, nil
+}
+
+func (file *secretsFile) inputPassword(key string) (string, error) {
+	if file.Master != nil {
+		return file.masterPassword()
+	}
+	return term.InputPassword("Please input password for %s", key)
+}
+
+func (file *secretsFile) inputNewPassword(key string) (string, error) {
+	if file.Master != nil {
+		return file.masterPassword()
+	}
+	return term.InputNewPassword("Please intput new password for %s", key)
+}
+
+// decryptAll decrypts all the secrets, in master-password mode, only one
+// password is required.
+func (file *secretsFile) decryptAll() (map[string]string, error) {
+	var master string
+	if file.Master != nil && len(file.Secrets) > 0 {
+		var err error
+		master, err = file.masterPassword()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := file.keys()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		s := file.Secrets[key]
+		if value, ok := agentGetSecret(key, s); ok {
+			values[key] = value
+			continue
+		}
+		password := master
+		if password == "" {
+			var err error
+			password, err = term.InputPassword("Please input password for %s", key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		value, err := s.decrypt(password)
+		if err != nil {
+			return nil, errors.Trace(err, "decrypt %s", key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (file *secretsFile) keys() []string {
+	keys := make([]string, 0, len(file.Secrets))
+	for key := range file.Secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func SetSecret(key string) (string, error) {
-	secrets, err := readSecrets()
+	file, err := readSecrets()
 	if err != nil {
 		return "", err
 	}
 
-	password, err := term.InputNewPassword("Please intput new password for %s", key)
+	password, err := file.inputNewPassword(key)
 	if err != nil {
 		return "", err
 	}
 	value := term.InputErase("Please input %s", key)
 
-	encrypted, salt, err := crypto.Encrypt(password, []byte(value), false)
+	err = file.store(key, password, value)
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// StoreSecret stores the given value as secret, the password is required
+// like SetSecret.
+func StoreSecret(key, value string) error {
+	file, err := readSecrets()
+	if err != nil {
+		return err
+	}
+	password, err := file.inputNewPassword(key)
+	if err != nil {
+		return err
+	}
+	return file.store(key, password, value)
+}
+
+func (file *secretsFile) store(key, password, value string) error {
+	s, err := encryptSecret(password, value)
 	if err != nil {
-		return "", errors.Trace(err, "encrypt")
+		return err
 	}
+	file.Secrets[key] = s
 
-	secrets[key] = &Secret{
+	err = writeSecrets(file)
+	if err != nil {
+		return err
+	}
+	agentSetSecret(key, s, value)
+	return nil
+}
+
+func encryptSecret(password, value string) (*Secret, error) {
+	kdf := crypto.DefaultKDF()
+	encrypted, salt, err := crypto.EncryptKDF(password, []byte(value), kdf)
+	if err != nil {
+		return nil, errors.Trace(err, "encrypt")
+	}
+	return &Secret{
 		Value: encrypted,
 		Salt:  salt,
-	}
+		KDF:   kdf,
+	}, nil
+}
 
-	return value, writeSecrets(secrets)
+func (s *Secret) decrypt(password string) (string, error) {
+	data, err := crypto.DecryptKDF(password, s.Salt, s.Value, s.KDF)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
 }
 
 func GetSecret(key string, allowCreate bool) (string, error) {
-	secrets, err := readSecrets()
+	file, err := readSecrets()
 	if err != nil {
 		return "", err
 	}
 
-	s, ok := secrets[key]
+	s, ok := file.Secrets[key]
 	if !ok {
 		if allowCreate {
 			ok = term.Confirm("The secret %q does not exists, do you want to create it", key)
@@ -99,23 +293,190 @@ func GetSecret(key string, allowCreate bool) (string, error) {
 		}
 		return "", fmt.Errorf("cannot find secret %q", key)
 	}
-	password, err := term.InputPassword("Please input password for %s", key)
+	if value, ok := agentGetSecret(key, s); ok {
+		return value, nil
+	}
+	password, err := file.inputPassword(key)
 	if err != nil {
 		return "", err
 	}
 
-	data, err := crypto.Decrypt(password, s.Salt, s.Value)
+	value, err := s.decrypt(password)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	agentSetSecret(key, s, value)
+	return value, nil
 }
 
 func DeleteSecret(key string) error {
-	secrets, err := readSecrets()
+	file, err := readSecrets()
+	if err != nil {
+		return err
+	}
+	delete(file.Secrets, key)
+	agentDeleteSecret(key)
+	return writeSecrets(file)
+}
+
+func SecretExists(key string) (bool, error) {
+	file, err := readSecrets()
+	if err != nil {
+		return false, err
+	}
+	_, ok := file.Secrets[key]
+	return ok, nil
+}
+
+// ForgetSecret removes the secret cached in agent, the secret in file is
+// kept.
+func ForgetSecret(key string) {
+	agentDeleteSecret(key)
+}
+
+func ListSecretKeys() ([]string, error) {
+	file, err := readSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return file.keys(), nil
+}
+
+// MigrateSecrets re-encrypts the secrets which are not using the default kdf
+// parameters. The password of each secret (or the master password) is
+// required, and the secrets file is written after each secret is migrated,
+// so the migration can be resumed.
+func MigrateSecrets() (int, error) {
+	file, err := readSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	kdf := crypto.DefaultKDF()
+	keys := file.outdatedKeys(kdf)
+
+	// The master password is read only once for all the secrets.
+	var master string
+	masterOutdated := file.Master != nil && !kdf.Equal(file.Master.KDF)
+	if file.Master != nil && (masterOutdated || len(keys) > 0) {
+		master, err = file.masterPassword()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if masterOutdated {
+		err = file.migrateMaster(master)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for i, key := range keys {
+		err = file.migrate(key, master)
+		if err != nil {
+			return i, err
+		}
+	}
+	if len(keys) == 0 && file.Version < secretsVersion {
+		// Only upgrade the file version.
+		return 0, writeSecrets(file)
+	}
+	return len(keys), nil
+}
+
+func (file *secretsFile) migrateMaster(password string) error {
+	var err error
+	file.Master, err = encryptSecret(password, masterCheck)
+	if err != nil {
+		return err
+	}
+	err = writeSecrets(file)
 	if err != nil {
 		return err
 	}
-	delete(secrets, key)
-	return writeSecrets(secrets)
+	agentSetSecret(agentMasterKey, file.Master, password)
+	term.PrintOperation("migrated master password")
+	return nil
+}
+
+func (file *secretsFile) outdatedKeys(kdf *crypto.KDF) []string {
+	var keys []string
+	for _, key := range file.keys() {
+		if !kdf.Equal(file.Secrets[key].KDF) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// migrate re-encrypts the secret, the password is required if the master
+// password is empty.
+func (file *secretsFile) migrate(key, master string) error {
+	password := master
+	if password == "" {
+		var err error
+		password, err = term.InputPassword("Please input password for %s", key)
+		if err != nil {
+			return err
+		}
+	}
+	value, err := file.Secrets[key].decrypt(password)
+	if err != nil {
+		return errors.Trace(err, "decrypt %s", key)
+	}
+	s, err := encryptSecret(password, value)
+	if err != nil {
+		return err
+	}
+	file.Secrets[key] = s
+	err = writeSecrets(file)
+	if err != nil {
+		return err
+	}
+	agentSetSecret(key, s, value)
+	term.PrintOperation("migrated %s", key)
+	return nil
+}
+
+// RekeySecrets re-encrypts all the secrets with a new password. If master is
+// true, the store will be switched to master-password mode. The store that
+// already uses master password keeps using it.
+func RekeySecrets(master bool) error {
+	file, err := readSecrets()
+	if err != nil {
+		return err
+	}
+	values, err := file.decryptAll()
+	if err != nil {
+		return err
+	}
+
+	password, err := term.InputNewPassword("Please input new password")
+	if err != nil {
+		return err
+	}
+	if master || file.Master != nil {
+		file.Master, err = encryptSecret(password, masterCheck)
+		if err != nil {
+			return err
+		}
+	}
+	for key, value := range values {
+		file.Secrets[key], err = encryptSecret(password, value)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeSecrets(file)
+	if err != nil {
+		return err
+	}
+
+	if file.Master != nil {
+		agentSetSecret(agentMasterKey, file.Master, password)
+	}
+	for key, value := range values {
+		agentSetSecret(key, file.Secrets[key], value)
+	}
+	return nil
 }
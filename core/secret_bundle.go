@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/term"
+	"gopkg.in/yaml.v3"
+)
+
+// The bundle is used to move secrets between machines, all the secrets are
+// encrypted as a whole by the bundle password, so that it does not depend on
+// the password mode of the source store.
+const secretsBundleVersion = 1
+
+type secretsBundle struct {
+	Version int `yaml:"version"`
+
+	Secret `yaml:",inline"`
+}
+
+// ExportSecrets decrypts all the secrets, and encrypts them into a bundle by
+// a new password.
+func ExportSecrets() ([]byte, error) {
+	file, err := readSecrets()
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Secrets) == 0 {
+		return nil, errors.New("no secret to export")
+	}
+	values, err := file.decryptAll()
+	if err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, errors.Trace(err, "encode secrets")
+	}
+
+	password, err := term.InputNewPassword("Please input password for the bundle")
+	if err != nil {
+		return nil, err
+	}
+	s, err := encryptSecret(password, string(data))
+	if err != nil {
+		return nil, err
+	}
+	bundle := &secretsBundle{
+		Version: secretsBundleVersion,
+		Secret:  *s,
+	}
+	data, err = yaml.Marshal(bundle)
+	return data, errors.Trace(err, "encode bundle")
+}
+
+// ImportSecrets decrypts the bundle and stores the secrets. If the secret
+// already exists, it will be overwritten after confirming.
+func ImportSecrets(data []byte) (int, error) {
+	var bundle secretsBundle
+	err := yaml.Unmarshal(data, &bundle)
+	if err != nil {
+		return 0, errors.Trace(err, "parse bundle")
+	}
+	if bundle.Version != secretsBundleVersion || bundle.Value == "" {
+		return 0, fmt.Errorf("invalid secrets bundle, version %d", bundle.Version)
+	}
+
+	password, err := term.InputPassword("Please input password for the bundle")
+	if err != nil {
+		return 0, err
+	}
+	raw, err := bundle.decrypt(password)
+	if err != nil {
+		return 0, err
+	}
+	var values map[string]string
+	err = yaml.Unmarshal([]byte(raw), &values)
+	if err != nil {
+		return 0, errors.Trace(err, "parse bundle secrets")
+	}
+
+	file, err := readSecrets()
+	if err != nil {
+		return 0, err
+	}
+	password, err = file.inputNewPassword("imported secrets")
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var count int
+	for _, key := range keys {
+		value := values[key]
+		if _, ok := file.Secrets[key]; ok {
+			if !term.Confirm("The secret %q already exists, do you want to overwrite it", key) {
+				continue
+			}
+		}
+		s, err := encryptSecret(password, value)
+		if err != nil {
+			return 0, err
+		}
+		file.Secrets[key] = s
+		agentDeleteSecret(key)
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, writeSecrets(file)
+}
@@ -0,0 +1,341 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fioncat/gitzombie/pkg/crypto"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/git"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"github.com/fioncat/gitzombie/pkg/term"
+)
+
+// The secret filter is a git clean/smudge filter, the files matched in
+// ".gitattributes" with "filter=gitzombie" are encrypted to the recipients
+// when they are staged, and decrypted when they are checked out. The
+// recipients are listed in RecipientsFile at the root of the repository, so
+// the team can share them.
+
+const (
+	FilterName = "gitzombie"
+
+	RecipientsFile = ".gitzombie-recipients"
+)
+
+// FilterClean encrypts the content from git. To avoid the file always being
+// shown as modified, the encrypted content in index is reused if it can be
+// decrypted to the same content and the recipients are not changed.
+func FilterClean(path string, in io.Reader, out io.Writer) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return errors.Trace(err, "read input")
+	}
+	if crypto.IsRecipientEncrypted(data) {
+		_, err = out.Write(data)
+		return err
+	}
+
+	recipients, err := readRepoRecipients()
+	if err != nil {
+		return err
+	}
+
+	prev, ok := gitBlob(":" + path)
+	if !ok {
+		prev, ok = gitBlob("HEAD:" + path)
+	}
+	if ok && crypto.EncryptedRecipientsTag(prev) == crypto.RecipientsTag(recipients) {
+		ids, err := ReadIdentities(nil)
+		if err == nil {
+			raw, err := crypto.DecryptIdentities(prev, ids)
+			if err == nil && bytes.Equal(raw, data) {
+				_, err = out.Write(prev)
+				return err
+			}
+		}
+	}
+
+	encrypted, err := crypto.EncryptRecipients(data, recipients)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(encrypted)
+	return err
+}
+
+// FilterSmudge decrypts the content from git. If we have no private key to
+// decrypt it, keep the content encrypted, so that checking out will not
+// fail.
+func FilterSmudge(path string, in io.Reader, out io.Writer) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return errors.Trace(err, "read input")
+	}
+	_, err = out.Write(decryptFilterData(path, data))
+	return err
+}
+
+// FilterTextconv prints the decrypted content of file, used by "git diff".
+func FilterTextconv(path string, out io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(decryptFilterData(path, data))
+	return err
+}
+
+func decryptFilterData(path string, data []byte) []byte {
+	if !crypto.IsRecipientEncrypted(data) {
+		return data
+	}
+	ids, err := ReadIdentities(nil)
+	if err != nil {
+		term.Warn("cannot decrypt %s: %v", path, err)
+		return data
+	}
+	raw, err := crypto.DecryptIdentities(data, ids)
+	if err != nil {
+		term.Warn("cannot decrypt %s: %v", path, err)
+		return data
+	}
+	return raw
+}
+
+func readRepoRecipients() ([]*crypto.Recipient, error) {
+	root, err := gitRoot()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(root, RecipientsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot find %s, please add recipients' public keys to it", RecipientsFile)
+		}
+		return nil, errors.Trace(err, "read recipients")
+	}
+
+	var recipients []*crypto.Recipient
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipient, err := crypto.ParseRecipient(line)
+		if err != nil {
+			return nil, errors.Trace(err, "parse %s", RecipientsFile)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipient in %s", RecipientsFile)
+	}
+	return recipients, nil
+}
+
+func gitRoot() (string, error) {
+	return git.Output([]string{"rev-parse", "--show-toplevel"}, git.Mute)
+}
+
+// gitBlob returns the raw content of a blob, git.Output is not used here
+// because it trims the output.
+func gitBlob(rev string) ([]byte, bool) {
+	var out bytes.Buffer
+	cmd := exec.Command("git", "cat-file", "blob", rev)
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// InstallFilter registers the filter to the git config of current repo, and
+// protects patterns by adding them to ".gitattributes". The protected files
+// that are still encrypted in work tree will be checked out again.
+func InstallFilter(patterns []string) error {
+	root, err := gitRoot()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Trace(err, "get executable")
+	}
+	exe = fmt.Sprintf("%q secret filter", exe)
+
+	opts := &git.Options{Path: root}
+	configs := [][2]string{
+		{"filter.gitzombie.clean", exe + " clean %f"},
+		{"filter.gitzombie.smudge", exe + " smudge %f"},
+		{"filter.gitzombie.required", "true"},
+		{"diff.gitzombie.textconv", exe + " textconv"},
+	}
+	for _, config := range configs {
+		err = git.Config(config[0], config[1], opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureRecipientsFile(root)
+	if err != nil {
+		return err
+	}
+
+	if len(patterns) > 0 {
+		err = addFilterAttributes(root, patterns)
+		if err != nil {
+			return err
+		}
+	}
+
+	files, err := ListFilterFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = refreshFilterFile(root, file.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureRecipientsFile creates the recipients file with our own public key
+// if it does not exist.
+func ensureRecipientsFile(root string) error {
+	path := filepath.Join(root, RecipientsFile)
+	exists, err := osutil.FileExists(path)
+	if err != nil {
+		return errors.Trace(err, "check recipients file")
+	}
+	if exists {
+		return nil
+	}
+	pub, err := os.ReadFile(IdentityPath() + ".pub")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("cannot find %s, please use \"gz secret keygen\" to generate key", RecipientsFile)
+		}
+		return errors.Trace(err, "read public key")
+	}
+	content := "# The public keys that can decrypt the protected files, one per line.\n" + string(pub)
+	term.PrintOperation("create %s", RecipientsFile)
+	return osutil.WriteFile(path, []byte(content))
+}
+
+func addFilterAttributes(root string, patterns []string) error {
+	path := filepath.Join(root, ".gitattributes")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err, "read .gitattributes")
+	}
+	existing := make(map[string]struct{})
+	for _, line := range strings.Split(string(data), "\n") {
+		existing[strings.TrimSpace(line)] = struct{}{}
+	}
+
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	for _, pattern := range patterns {
+		line := fmt.Sprintf("%s filter=%s diff=%s", pattern, FilterName, FilterName)
+		if _, ok := existing[line]; ok {
+			continue
+		}
+		term.PrintOperation("protect %s", pattern)
+		content += line + "\n"
+	}
+	return osutil.WriteFile(path, []byte(content))
+}
+
+func refreshFilterFile(root, path string) error {
+	fullPath := filepath.Join(root, path)
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !crypto.IsRecipientEncrypted(data) {
+		return nil
+	}
+	blob, ok := gitBlob(":" + path)
+	if !ok || !bytes.Equal(blob, data) {
+		// The file is modified, don't override it.
+		return nil
+	}
+	// Git will not check out the file if it is not modified, so remove it
+	// first.
+	err = os.Remove(fullPath)
+	if err != nil {
+		return err
+	}
+	return git.Exec([]string{"checkout", "--", path}, &git.Options{Path: root})
+}
+
+type FilterFile struct {
+	Path string
+
+	// Encrypted reports whether the file is encrypted in index. If not, the
+	// file was added before being protected, and might be committed in
+	// plain text.
+	Encrypted bool
+}
+
+// ListFilterFiles lists the tracked files that are protected by the filter.
+func ListFilterFiles() ([]*FilterFile, error) {
+	root, err := gitRoot()
+	if err != nil {
+		return nil, err
+	}
+	files, err := git.OutputItems([]string{"ls-files"}, &git.Options{
+		Path:     root,
+		QuietCmd: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "check-attr", "--stdin", "filter")
+	cmd.Dir = root
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\n") + "\n")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, errors.Trace(err, "git check-attr")
+	}
+
+	var result []*FilterFile
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		// The format is "{path}: filter: {value}"
+		line := scanner.Text()
+		path, value, ok := strings.Cut(line, ": filter: ")
+		if !ok || value != FilterName {
+			continue
+		}
+		blob, _ := gitBlob(":" + path)
+		result = append(result, &FilterFile{
+			Path:      path,
+			Encrypted: crypto.IsRecipientEncrypted(blob),
+		})
+	}
+	return result, nil
+}
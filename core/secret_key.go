@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/crypto"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// IdentityPath returns the default private key path generated by
+// "gz secret keygen", the public key is stored in the same path with ".pub"
+// suffix.
+func IdentityPath() string {
+	return config.GetDir("key")
+}
+
+// GenerateIdentity generates a new key pair and writes it to path. The
+// private key is only readable by current user.
+func GenerateIdentity(path string) (*crypto.Recipient, error) {
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	recipient := id.Recipient()
+
+	err = osutil.EnsureDir(filepath.Dir(path))
+	if err != nil {
+		return nil, errors.Trace(err, "ensure key dir")
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", recipient, id)
+	err = os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		return nil, errors.Trace(err, "write private key")
+	}
+	err = os.WriteFile(path+".pub", []byte(recipient.String()+"\n"), 0644)
+	return recipient, errors.Trace(err, "write public key")
+}
+
+// ReadRecipients parses recipients, each one can be a public key string or
+// a public key file.
+func ReadRecipients(names []string) ([]*crypto.Recipient, error) {
+	recipients := make([]*crypto.Recipient, 0, len(names))
+	for _, name := range names {
+		content := name
+		if !crypto.IsRecipientString(name) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, errors.Trace(err, "read public key")
+			}
+			content = string(data)
+		}
+		recipient, err := crypto.ParseRecipient(content)
+		if err != nil {
+			return nil, errors.Trace(err, "recipient %s", name)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// ReadIdentities reads private key files, if no path is given, use the
+// default key generated by "gz secret keygen".
+func ReadIdentities(paths []string) ([]*crypto.Identity, error) {
+	if len(paths) == 0 {
+		paths = []string{IdentityPath()}
+	}
+	ids := make([]*crypto.Identity, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("cannot find private key %s, please use \"gz secret keygen\" to generate it", path)
+			}
+			return nil, errors.Trace(err, "read private key")
+		}
+		id, err := crypto.ParseIdentity(string(data))
+		if err != nil {
+			return nil, errors.Trace(err, "identity %s", path)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
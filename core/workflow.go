@@ -2,25 +2,44 @@ package core
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/fioncat/gitzombie/pkg/cron"
 	"github.com/fioncat/gitzombie/pkg/errors"
-	"github.com/fioncat/gitzombie/pkg/git"
 	"github.com/fioncat/gitzombie/pkg/osutil"
+	"github.com/fioncat/gitzombie/pkg/term"
 	"github.com/fioncat/gitzombie/pkg/validate"
+	"github.com/iancoleman/strcase"
 )
 
 type Workflow struct {
 	Select *WorkflowSelect `yaml:"select"`
 
+	// Inputs are the parameters of workflow, passed by "gz run -p" and
+	// exported to jobs as "INPUT_{NAME}" env.
+	Inputs []*WorkflowInput `yaml:"inputs" validate:"unique=Name,dive"`
+
+	// Secrets are exported to all jobs, see Job.Secrets.
+	Secrets map[string]string `yaml:"secrets"`
+
 	Jobs []*Job `yaml:"jobs" validate:"required,dive"`
+
+	// Schedule is a cron expression to run the workflow by "gz daemon",
+	// such as "0 3 * * *" or "@daily".
+	Schedule string `yaml:"schedule"`
+
+	schedule *cron.Schedule
 }
 
-type WorkflowSelect struct {
-	Repos []string `yaml:"repos"`
-	Dirs  []string `yaml:"dirs"`
+type WorkflowInput struct {
+	Name        string `yaml:"name" validate:"required"`
+	Description string `yaml:"description"`
+
+	Default  string `yaml:"default"`
+	Required bool   `yaml:"required"`
+
+	// Enum limits the value of input, empty means any value.
+	Enum []string `yaml:"enum"`
 }
 
 func ListWorkflowNames() ([]string, error) {
@@ -29,159 +48,115 @@ func ListWorkflowNames() ([]string, error) {
 
 func GetWorkflow(name string) (*Workflow, error) {
 	return getConfigObject("workflows", yamlExt, "workflow", name, func(w *Workflow) error {
-		return validate.Do(w)
+		return w.Validate()
 	})
 }
 
-type WorkflowMatchItem struct {
-	Path string
-	Env  osutil.Env
-
-	Repo *Repository
-}
-
-func (s *WorkflowSelect) Match(store *RepositoryStorage) ([]*WorkflowMatchItem, error) {
-	var items []*WorkflowMatchItem
-	if len(s.Repos) > 0 {
-		repos, err := s.matchRepos(store)
+func (w *Workflow) Validate() error {
+	err := validate.Do(w)
+	if err != nil {
+		return err
+	}
+	if w.Schedule != "" {
+		w.schedule, err = cron.Parse(w.Schedule)
 		if err != nil {
-			return nil, err
-		}
-		for _, matchRepo := range repos {
-			env := make(osutil.Env)
-			var remote *Remote
-			if matchRepo.Remote != "" {
-				remote, err = GetRemote(matchRepo.Remote)
-				if err != nil {
-					return nil, errors.Trace(err, "get remote %q", matchRepo.Remote)
-				}
-			}
-			err = matchRepo.SetEnv(remote, env)
-			if err != nil {
-				return nil, errors.Trace(err, "set repo env")
-			}
-			items = append(items, &WorkflowMatchItem{
-				Path: matchRepo.Path,
-				Env:  env,
-				Repo: matchRepo,
-			})
+			return errors.Trace(err, "schedule")
 		}
 	}
-	if len(s.Dirs) > 0 {
-		dirs, err := s.matchDirs()
+	if w.Select != nil {
+		err = w.Select.validate()
 		if err != nil {
-			return nil, err
-		}
-		for _, dir := range dirs {
-			items = append(items, &WorkflowMatchItem{
-				Path: dir,
-				Env:  make(osutil.Env),
-			})
+			return errors.Trace(err, "select")
 		}
 	}
-	uniqueItems := make([]*WorkflowMatchItem, 0, len(items))
-	set := make(map[string]struct{}, len(items))
-	for _, item := range items {
-		if _, ok := set[item.Path]; ok {
+	for _, input := range w.Inputs {
+		if input.Default == "" {
+			if input.Required && w.Schedule != "" {
+				// The daemon cannot ask input from terminal.
+				return fmt.Errorf("scheduled workflow requires default value for input %s", input.Name)
+			}
 			continue
 		}
-		set[item.Path] = struct{}{}
-		uniqueItems = append(uniqueItems, item)
-	}
-	return uniqueItems, nil
-}
-
-type workflowRepoMatch struct {
-	remote  string
-	pattern string
-}
-
-func (m *workflowRepoMatch) match(store *RepositoryStorage) ([]*Repository, error) {
-	repos := store.List(m.remote)
-	var filters []*Repository
-	for _, repo := range repos {
-		ok, err := filepath.Match(m.pattern, repo.Name)
+		err = input.validate(input.Default)
 		if err != nil {
-			return nil, fmt.Errorf("invalid pattern %q: %v", m.pattern, err)
-		}
-		if ok {
-			filters = append(filters, repo)
+			return errors.Trace(err, "default value of input %s", input.Name)
 		}
 	}
-	return repos, nil
+	return validateJobs(w.Jobs)
 }
 
-func (s *WorkflowSelect) matchRepos(store *RepositoryStorage) ([]*Repository, error) {
-	repoMaches := make([]*workflowRepoMatch, len(s.Repos))
-	for i, repoMatchStr := range s.Repos {
-		var remoteName string
-		var repoPattern string
-		tmp := strings.Split(repoMatchStr, ":")
-		switch len(tmp) {
-		case 1:
-			remoteName = tmp[0]
-			repoPattern = "*"
-
-		default:
-			remoteName = tmp[0]
-			repoPattern = strings.Join(tmp[1:], ":")
-			if repoPattern == "" {
-				repoPattern = "*"
-			}
-		}
-		repoMaches[i] = &workflowRepoMatch{
-			remote:  remoteName,
-			pattern: repoPattern,
-		}
+// ResolveInputs validates the params passed by user and converts them to
+// env. The missing required inputs are asked from terminal.
+func (w *Workflow) ResolveInputs(params map[string]string) (osutil.Env, error) {
+	inputs := make(map[string]*WorkflowInput, len(w.Inputs))
+	for _, input := range w.Inputs {
+		inputs[input.Name] = input
 	}
-
-	var matchRepos []*Repository
-	for _, repoMatch := range repoMaches {
-		repos, err := repoMatch.match(store)
-		if err != nil {
-			return nil, err
+	for key := range params {
+		if _, ok := inputs[key]; !ok {
+			return nil, fmt.Errorf("unknown input %q", key)
 		}
-		matchRepos = append(matchRepos, repos...)
 	}
-	return matchRepos, nil
-}
 
-func (s *WorkflowSelect) matchDirs() ([]string, error) {
-	var dirs []string
-	for _, dir := range s.Dirs {
-		var scan bool
-		if strings.HasSuffix(dir, "*") {
-			scan = true
-			dir = strings.TrimSuffix(dir, "*")
+	env := make(osutil.Env, len(w.Inputs))
+	for _, input := range w.Inputs {
+		value, ok := params[input.Name]
+		if !ok {
+			value = input.Default
 		}
-		dir = os.ExpandEnv(dir)
-		exists, err := osutil.DirExists(dir)
-		if err != nil {
-			return nil, errors.Trace(err, "check dir exists")
-		}
-		if !exists {
-			return nil, fmt.Errorf("%s is not exists", dir)
-		}
-		if !scan {
-			err = git.EnsurePath(dir)
+		if value == "" && input.Required {
+			var err error
+			value, err = input.ask()
 			if err != nil {
-				if err == git.ErrNotGit {
-					return nil, fmt.Errorf("%s is not a git repository", dir)
-				}
-				return nil, errors.Trace(err, "check git dir")
+				return nil, err
 			}
-			dirs = append(dirs, dir)
-			continue
 		}
-		dir = strings.TrimSuffix(dir, "/")
-		scanDir := strings.TrimSuffix(dir, "*")
-		items, err := git.Discover(scanDir)
+		err := input.validate(value)
 		if err != nil {
-			return nil, errors.Trace(err, "scan git dir")
+			return nil, err
 		}
-		for _, item := range items {
-			dirs = append(dirs, item.Path)
+		env[input.EnvName()] = value
+	}
+	return env, nil
+}
+
+// EnvName returns the env name of input, such as "INPUT_GO_VERSION" for
+// "go-version".
+func (input *WorkflowInput) EnvName() string {
+	return "INPUT_" + strcase.ToScreamingSnake(input.Name)
+}
+
+func (input *WorkflowInput) ask() (string, error) {
+	msg := fmt.Sprintf("Please input %s", input.Name)
+	if input.Description != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, input.Description)
+	}
+	if len(input.Enum) > 0 {
+		msg = fmt.Sprintf("%s [%s]", msg, strings.Join(input.Enum, "/"))
+	}
+	return term.Input(msg)
+}
+
+func (input *WorkflowInput) validate(value string) error {
+	if value == "" {
+		if input.Required {
+			return fmt.Errorf("input %s is required", input.Name)
+		}
+		return nil
+	}
+	if len(input.Enum) == 0 {
+		return nil
+	}
+	for _, enum := range input.Enum {
+		if value == enum {
+			return nil
 		}
 	}
-	return dirs, nil
+	return fmt.Errorf("invalid value %q for input %s, expect one of %q", value, input.Name, input.Enum)
+}
+
+// ResolveSecrets gets the secrets used by the workflow before running, so
+// that the jobs running in background don't need to ask password.
+func (w *Workflow) ResolveSecrets() error {
+	return ResolveJobSecrets(w.Jobs, w.Secrets)
 }
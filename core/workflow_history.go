@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// The triggers of workflow run.
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
+
+// WorkflowHistory records a workflow run on the selected repos. The history
+// is stored as json lines in local dir, only the latest entries are kept.
+type WorkflowHistory struct {
+	Workflow string        `json:"workflow"`
+	Trigger  string        `json:"trigger"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+
+	Repos  int    `json:"repos"`
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+
+	// Report is the path passed by "--report", see WorkflowReport.Write.
+	Report string `json:"report,omitempty"`
+}
+
+const maxWorkflowHistory = 1000
+
+var errLocked = errors.New("file is locked")
+
+func workflowHistoryPath() string {
+	return config.GetLocalDir("workflow", "history.jsonl")
+}
+
+// AppendWorkflowHistory records the run, the oldest entries are dropped if
+// there are too many.
+func AppendWorkflowHistory(history *WorkflowHistory) error {
+	path := workflowHistoryPath()
+	lock, err := waitLockFile(path + ".lock")
+	if err != nil {
+		return errors.Trace(err, "lock history")
+	}
+	defer lock.Close()
+
+	histories, err := readWorkflowHistory(path)
+	if err != nil {
+		return err
+	}
+	histories = append(histories, history)
+	if len(histories) > maxWorkflowHistory {
+		histories = histories[len(histories)-maxWorkflowHistory:]
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, history := range histories {
+		err = encoder.Encode(history)
+		if err != nil {
+			return errors.Trace(err, "encode history")
+		}
+	}
+	tmpPath := path + ".tmp"
+	err = osutil.WriteFile(tmpPath, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return errors.Trace(os.Rename(tmpPath, path), "replace history file")
+}
+
+// ListWorkflowHistory returns the history of workflow, the latest first. All
+// the workflows are returned if name is empty.
+func ListWorkflowHistory(name string) ([]*WorkflowHistory, error) {
+	histories, err := readWorkflowHistory(workflowHistoryPath())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*WorkflowHistory, 0, len(histories))
+	for i := len(histories) - 1; i >= 0; i-- {
+		if name == "" || histories[i].Workflow == name {
+			result = append(result, histories[i])
+		}
+	}
+	return result, nil
+}
+
+func readWorkflowHistory(path string) ([]*WorkflowHistory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err, "open history file")
+	}
+	defer file.Close()
+
+	var histories []*WorkflowHistory
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var history WorkflowHistory
+		err = json.Unmarshal(line, &history)
+		if err != nil {
+			return nil, fmt.Errorf("parse history file %s: %v", path, err)
+		}
+		histories = append(histories, &history)
+	}
+	return histories, errors.Trace(scanner.Err(), "read history file")
+}
+
+// LockWorkflow makes sure that a workflow has only one run at the same time,
+// both "gz run" and the daemon use it. The returned function releases the
+// lock.
+func LockWorkflow(name string) (func(), error) {
+	file, err := lockFile(config.GetLocalDir("workflow", "lock", name))
+	if err != nil {
+		if err == errLocked {
+			return nil, fmt.Errorf("workflow %s is already running", name)
+		}
+		return nil, errors.Trace(err, "lock workflow")
+	}
+	return func() { file.Close() }, nil
+}
+
+// lockFile takes the exclusive flock of file without blocking, errLocked is
+// returned if the file is locked by others. The lock is released when the
+// file is closed or the process exits.
+func lockFile(path string) (*os.File, error) {
+	return openLockFile(path, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func waitLockFile(path string) (*os.File, error) {
+	return openLockFile(path, syscall.LOCK_EX)
+}
+
+func openLockFile(path string, how int) (*os.File, error) {
+	err := osutil.EnsureDir(filepath.Dir(path))
+	if err != nil {
+		return nil, errors.Trace(err, "ensure lock dir")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Trace(err, "open lock file")
+	}
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, errors.Trace(err, "flock")
+	}
+	return file, nil
+}
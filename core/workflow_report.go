@@ -0,0 +1,184 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// WorkflowReport records a workflow run on multiple repos.
+type WorkflowReport struct {
+	Workflow string        `json:"workflow"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+
+	Repos []*RepoReport `json:"repos"`
+}
+
+// RepoReport records the jobs executed in one repo.
+type RepoReport struct {
+	Path string `json:"path"`
+	// Repo is empty if the path is not managed by gitzombie.
+	Repo string `json:"repo,omitempty"`
+
+	Status   JobStatus     `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+
+	Jobs []*JobReport `json:"jobs"`
+}
+
+func NewRepoReport(item *WorkflowMatchItem, run *JobRun, duration time.Duration, err error) *RepoReport {
+	report := &RepoReport{
+		Path:     item.Path,
+		Status:   JobSuccess,
+		Duration: duration,
+		Jobs:     run.Reports,
+	}
+	if item.Repo != nil {
+		report.Repo = item.Repo.FullName()
+	}
+	if err != nil {
+		report.Status = JobFailure
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// Failed returns the number of failed repos.
+func (r *WorkflowReport) Failed() int {
+	var count int
+	for _, repo := range r.Repos {
+		if repo.Status == JobFailure {
+			count++
+		}
+	}
+	return count
+}
+
+// Write writes the report to path, the format is decided by the extension:
+// ".json" or ".md". For other paths, both "{path}.json" and "{path}.md"
+// are written.
+func (r *WorkflowReport) Write(path string) ([]string, error) {
+	switch filepath.Ext(path) {
+	case ".json":
+		return []string{path}, r.writeJSON(path)
+
+	case ".md":
+		return []string{path}, r.writeMarkdown(path)
+	}
+	jsonPath, mdPath := path+".json", path+".md"
+	err := r.writeJSON(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	err = r.writeMarkdown(mdPath)
+	if err != nil {
+		return nil, err
+	}
+	return []string{jsonPath, mdPath}, nil
+}
+
+func (r *WorkflowReport) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Trace(err, "encode report")
+	}
+	return osutil.WriteFile(path, append(data, '\n'))
+}
+
+func (r *WorkflowReport) writeMarkdown(path string) error {
+	return osutil.WriteFile(path, []byte(r.Markdown()))
+}
+
+// Markdown renders the report as a summary table followed by the jobs of
+// each repo.
+func (r *WorkflowReport) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Workflow %s\n\n", r.Workflow)
+	fmt.Fprintf(&sb, "Started at %s, took %s. %d repos, %d failed.\n\n",
+		r.Start.Format(time.RFC3339), formatDuration(r.Duration), len(r.Repos), r.Failed())
+
+	sb.WriteString("| Repo | Status | Duration | Jobs |\n")
+	sb.WriteString("| ---- | ------ | -------- | ---- |\n")
+	for _, repo := range r.Repos {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", markdownCell(repo.Name()),
+			repo.Status, formatDuration(repo.Duration), markdownCell(repo.JobsSummary()))
+	}
+
+	for _, repo := range r.Repos {
+		fmt.Fprintf(&sb, "\n## %s\n\n", repo.Name())
+		if repo.Error != "" {
+			fmt.Fprintf(&sb, "Error: %s\n\n", markdownCell(repo.Error))
+		}
+		sb.WriteString("| Job | Status | Duration | Exit code | Attempts |\n")
+		sb.WriteString("| --- | ------ | -------- | --------- | -------- |\n")
+		for _, job := range repo.Jobs {
+			var exitCode, attempts string
+			if job.Attempts > 0 {
+				exitCode = fmt.Sprint(job.ExitCode)
+				attempts = fmt.Sprint(job.Attempts)
+			}
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s |\n", markdownCell(job.Name),
+				job.Status, formatDuration(job.Duration), exitCode, attempts)
+		}
+		for _, job := range repo.Jobs {
+			if job.Output == "" {
+				continue
+			}
+			fmt.Fprintf(&sb, "\n<details><summary>Output of %s</summary>\n\n", job.Name)
+			fmt.Fprintf(&sb, "````\n%s", job.Output)
+			if !strings.HasSuffix(job.Output, "\n") {
+				sb.WriteString("\n")
+			}
+			sb.WriteString("````\n\n</details>\n")
+		}
+	}
+	return sb.String()
+}
+
+// Name returns the repo name, or path if it is not a repo.
+func (r *RepoReport) Name() string {
+	if r.Repo != "" {
+		return r.Repo
+	}
+	return r.Path
+}
+
+// JobsSummary returns the summary of jobs, such as "2/3 success, failed:
+// build".
+func (r *RepoReport) JobsSummary() string {
+	var success int
+	var failed []string
+	for _, job := range r.Jobs {
+		switch job.Status {
+		case JobSuccess:
+			success++
+
+		case JobFailure:
+			failed = append(failed, job.Name)
+		}
+	}
+	summary := fmt.Sprintf("%d/%d success", success, len(r.Jobs))
+	if len(failed) > 0 {
+		summary = fmt.Sprintf("%s, failed: %s", summary, strings.Join(failed, ", "))
+	}
+	return summary
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond * 100).String()
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
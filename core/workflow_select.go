@@ -0,0 +1,731 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"github.com/fioncat/gitzombie/pkg/git"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+)
+
+// WorkflowSelect selects the repos to run workflow. The sources (Repos,
+// Regex, Groups and Dirs) are combined with "or", then the repos are
+// filtered by all the other conditions ("and"). The sub selectors in Any
+// and All filter the result further:
+//
+//	select:
+//	  repos: ["github:fioncat/*"]
+//	  exclude: ["github:fioncat/dotfiles"]
+//	  accessed_within: 30d
+//	  any:
+//	    - labels: [backend]
+//	    - git: {files: [go.mod]}
+//	  top: 10
+type WorkflowSelect struct {
+	// Repos selects repos by "remote[:glob]", such as "github:fioncat/*".
+	// The remote without glob selects all its repos.
+	Repos []string `yaml:"repos"`
+	// Regex selects repos whose full name ("remote:name") matches the
+	// regular expressions.
+	Regex []string `yaml:"regex"`
+	// Groups selects repos by "remote:group", including the sub groups.
+	Groups []string `yaml:"groups"`
+	// Dirs selects git directories, "dir/*" selects all the git repos under
+	// dir.
+	Dirs []string `yaml:"dirs"`
+
+	// RemoteRepos selects repos from remote API by "remote:group/glob", such
+	// as "github:myorg/*", the repos don't need to be cloned locally. Each
+	// of them is cloned (shallow) to a temporary path to run jobs, see
+	// WorkflowMatchItem.CloneTemp. Only Exclude is applied to them, and it
+	// is only supported by the top select.
+	RemoteRepos []string `yaml:"remote_repos"`
+
+	// Exclude removes the repos matching "remote[:glob]" or the paths
+	// matching glob.
+	Exclude []string `yaml:"exclude"`
+
+	// Labels requires repos to have all the labels, see "gz label".
+	Labels []string `yaml:"labels"`
+
+	// AccessedWithin requires repos to be accessed in the duration, such as
+	// "12h", "7d" or "2w".
+	AccessedWithin string `yaml:"accessed_within"`
+
+	Git *WorkflowGitSelect `yaml:"git"`
+
+	// Any keeps the repos matching any of the sub selectors.
+	Any []*WorkflowSelect `yaml:"any"`
+	// All keeps the repos matching all of the sub selectors.
+	All []*WorkflowSelect `yaml:"all"`
+
+	// Top keeps the repos with the highest frecency scores, it is applied
+	// after all the other conditions.
+	Top int `yaml:"top" validate:"gte=0"`
+
+	parsed bool
+
+	repos          []*workflowRepoMatch
+	remoteRepos    []*workflowRepoMatch
+	regex          []*regexp.Regexp
+	groups         []*workflowRepoMatch
+	exclude        []*workflowRepoMatch
+	accessedWithin time.Duration
+
+	dirs []*workflowDir
+}
+
+// WorkflowGitSelect selects repos by git state, git commands are executed
+// in every repo, so put it after cheaper conditions.
+type WorkflowGitSelect struct {
+	// Dirty selects repos with (true) or without (false) uncommitted
+	// changes.
+	Dirty *bool `yaml:"dirty"`
+
+	// Branch selects repos whose current branch matches any of the globs.
+	Branch []string `yaml:"branch"`
+
+	// Files selects repos having any file matching the globs, such as
+	// "go.mod".
+	Files []string `yaml:"files"`
+}
+
+type WorkflowMatchItem struct {
+	Path string
+	Env  osutil.Env
+
+	Repo *Repository
+
+	// Clone is the url to clone the repo selected by RemoteRepos, the Path
+	// is empty until the repo is cloned.
+	Clone string
+
+	remote *Remote
+
+	git *workflowGitState
+}
+
+type workflowGitState struct {
+	dirty  *bool
+	branch *string
+}
+
+type workflowRepoMatch struct {
+	remote  string
+	pattern string
+}
+
+type workflowDir struct {
+	path string
+	scan bool
+}
+
+func (s *WorkflowSelect) validate() error {
+	s.parsed = true
+	err := s.validateRepoMatches()
+	if err != nil {
+		return err
+	}
+	err = s.validateConditions()
+	if err != nil {
+		return err
+	}
+	for _, sub := range append(s.Any, s.All...) {
+		if sub == nil {
+			return errors.New("empty sub selector")
+		}
+		if len(sub.RemoteRepos) > 0 {
+			return errors.New("remote_repos is not supported in sub selector")
+		}
+		err = sub.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WorkflowSelect) validateRepoMatches() error {
+	var err error
+	s.repos, err = parseRepoMatches(s.Repos, "*")
+	if err != nil {
+		return errors.Trace(err, "repos")
+	}
+	s.remoteRepos, err = parseRepoMatches(s.RemoteRepos, "")
+	if err != nil {
+		return errors.Trace(err, "remote_repos")
+	}
+	for _, m := range s.remoteRepos {
+		if m.pattern == "" {
+			return fmt.Errorf("remote_repos: missing group in %q", m.remote)
+		}
+		// The provider can only list repos of a concrete group.
+		group, _, _ := strings.Cut(strings.Trim(m.pattern, "/"), "/")
+		if strings.ContainsAny(group, "*?[\\") {
+			return fmt.Errorf("remote_repos: glob is not supported in group of %q", m.remote+":"+m.pattern)
+		}
+	}
+	s.groups, err = parseRepoMatches(s.Groups, "")
+	if err != nil {
+		return errors.Trace(err, "groups")
+	}
+	for _, group := range s.groups {
+		if group.pattern == "" {
+			return fmt.Errorf("groups: missing group in %q", group.remote)
+		}
+	}
+	s.exclude, err = parseRepoMatches(s.Exclude, "*")
+	return errors.Trace(err, "exclude")
+}
+
+func (s *WorkflowSelect) validateConditions() error {
+	var err error
+	s.regex = make([]*regexp.Regexp, len(s.Regex))
+	for i, expr := range s.Regex {
+		s.regex[i], err = regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("regex: invalid %q: %v", expr, err)
+		}
+	}
+
+	if s.AccessedWithin != "" {
+		s.accessedWithin, err = parseAge(s.AccessedWithin)
+		if err != nil {
+			return errors.Trace(err, "accessed_within")
+		}
+	}
+
+	if s.Git != nil {
+		for _, pattern := range append(s.Git.Branch, s.Git.Files...) {
+			_, err = path.Match(pattern, "")
+			if err != nil {
+				return fmt.Errorf("git: invalid pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func parseRepoMatches(ss []string, defaultPattern string) ([]*workflowRepoMatch, error) {
+	matches := make([]*workflowRepoMatch, len(ss))
+	for i, s := range ss {
+		remote, pattern, _ := strings.Cut(s, ":")
+		if remote == "" {
+			return nil, fmt.Errorf("missing remote in %q", s)
+		}
+		if pattern == "" {
+			pattern = defaultPattern
+		}
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		matches[i] = &workflowRepoMatch{
+			remote:  remote,
+			pattern: pattern,
+		}
+	}
+	return matches, nil
+}
+
+// parseAge parses the duration, supports "d" (day) and "w" (week) besides
+// the units of time.ParseDuration.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if value, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func (m *workflowRepoMatch) match(repo *Repository) bool {
+	if repo.Remote != m.remote {
+		return false
+	}
+	if m.pattern == "*" {
+		// The "*" cannot match the "/" in repo name, treat it as all repos.
+		return true
+	}
+	ok, _ := path.Match(m.pattern, repo.Name)
+	return ok
+}
+
+func (m *workflowRepoMatch) matchGroup(repo *Repository) bool {
+	if repo.Remote != m.remote {
+		return false
+	}
+	group := strings.Trim(m.pattern, "/")
+	return repo.Group() == group || strings.HasPrefix(repo.Group(), group+"/")
+}
+
+func (d *workflowDir) match(path string) bool {
+	if d.scan {
+		return strings.HasPrefix(path, d.path+"/")
+	}
+	return path == d.path
+}
+
+// Match returns the selected repos (and git directories), the Env of repos
+// are set.
+func (s *WorkflowSelect) Match(store *RepositoryStorage) ([]*WorkflowMatchItem, error) {
+	if !s.parsed {
+		// The select is not loaded by GetWorkflow.
+		err := s.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.empty() {
+		return nil, nil
+	}
+
+	repos := store.List("")
+	items := make([]*WorkflowMatchItem, 0, len(repos))
+	paths := make(map[string]struct{}, len(repos))
+	for _, repo := range repos {
+		items = append(items, &WorkflowMatchItem{Path: repo.Path, Repo: repo})
+		paths[repo.Path] = struct{}{}
+	}
+	// The git directories not managed are selected by Dirs only.
+	dirs, err := s.resolveDirs()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if _, ok := paths[dir]; ok {
+			continue
+		}
+		paths[dir] = struct{}{}
+		items = append(items, &WorkflowMatchItem{Path: dir})
+	}
+
+	items, err = s.match(items)
+	if err != nil {
+		return nil, err
+	}
+
+	remotes := make(map[string]*Remote)
+	for _, item := range items {
+		item.Env = make(osutil.Env)
+		if item.Repo == nil {
+			continue
+		}
+		remote, ok := remotes[item.Repo.Remote]
+		if !ok && item.Repo.Remote != "" {
+			remote, err = GetRemote(item.Repo.Remote)
+			if err != nil {
+				return nil, errors.Trace(err, "get remote %q", item.Repo.Remote)
+			}
+			remotes[item.Repo.Remote] = remote
+		}
+		err = item.Repo.SetEnv(remote, item.Env)
+		if err != nil {
+			return nil, errors.Trace(err, "set repo env")
+		}
+	}
+	return items, nil
+}
+
+// empty returns true if the select has no condition, which selects nothing
+// rather than all repos.
+func (s *WorkflowSelect) empty() bool {
+	if len(s.Repos) > 0 || len(s.Regex) > 0 || len(s.Groups) > 0 ||
+		len(s.Dirs) > 0 || len(s.RemoteRepos) > 0 || len(s.Exclude) > 0 || len(s.Labels) > 0 ||
+		s.AccessedWithin != "" || s.Git != nil || s.Top > 0 {
+		return false
+	}
+	for _, sub := range append(s.Any, s.All...) {
+		if !sub.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *WorkflowSelect) match(items []*WorkflowMatchItem) ([]*WorkflowMatchItem, error) {
+	var matched []*WorkflowMatchItem
+	for _, item := range items {
+		ok, err := s.matchItem(item)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+
+	matched, err := s.matchAny(matched)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range s.All {
+		matched, err = sub.match(matched)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.top(matched), nil
+}
+
+// matchAny keeps the items matching any of the Any selectors, the order is
+// not changed.
+func (s *WorkflowSelect) matchAny(items []*WorkflowMatchItem) ([]*WorkflowMatchItem, error) {
+	if len(s.Any) == 0 {
+		return items, nil
+	}
+	set := make(map[*WorkflowMatchItem]struct{}, len(items))
+	for _, sub := range s.Any {
+		subItems, err := sub.match(items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range subItems {
+			set[item] = struct{}{}
+		}
+	}
+	anyItems := make([]*WorkflowMatchItem, 0, len(set))
+	for _, item := range items {
+		if _, ok := set[item]; ok {
+			anyItems = append(anyItems, item)
+		}
+	}
+	return anyItems, nil
+}
+
+// top keeps the Top items with the highest frecency scores.
+func (s *WorkflowSelect) top(items []*WorkflowMatchItem) []*WorkflowMatchItem {
+	if s.Top <= 0 || len(items) <= s.Top {
+		return items
+	}
+	scores := make(map[*WorkflowMatchItem]uint64, len(items))
+	for _, item := range items {
+		if item.Repo != nil {
+			scores[item] = item.Repo.Score()
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return scores[items[i]] > scores[items[j]]
+	})
+	return items[:s.Top]
+}
+
+// MatchRemote returns the repos selected by RemoteRepos, list is used to
+// list the repo names in a group from remote API. The repos in items (from
+// Match) are skipped, so that they won't be run twice.
+func (s *WorkflowSelect) MatchRemote(items []*WorkflowMatchItem, list func(remote *Remote, group string) ([]string, error)) ([]*WorkflowMatchItem, error) {
+	if !s.parsed {
+		err := s.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	selected := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		if item.Repo != nil {
+			selected[item.Repo.FullName()] = struct{}{}
+		}
+	}
+
+	var remoteItems []*WorkflowMatchItem
+	for _, m := range s.remoteRepos {
+		remote, err := GetRemote(m.remote)
+		if err != nil {
+			return nil, errors.Trace(err, "get remote %q", m.remote)
+		}
+		group, pattern := m.remoteGroup()
+		names, err := list(remote, group)
+		if err != nil {
+			return nil, errors.Trace(err, "list repos of %s:%s", remote.Name, group)
+		}
+		for _, name := range names {
+			item, err := s.remoteItem(remote, name, pattern, selected)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				remoteItems = append(remoteItems, item)
+			}
+		}
+	}
+	return remoteItems, nil
+}
+
+// remoteItem builds the match item for a listed remote repo, it returns nil
+// if the name does not match pattern, is excluded or is already selected.
+func (s *WorkflowSelect) remoteItem(remote *Remote, name, pattern string, selected map[string]struct{}) (*WorkflowMatchItem, error) {
+	repo := &Repository{Name: name, Remote: remote.Name}
+	repo.group, repo.base = SplitGroup(name)
+	if _, ok := selected[repo.FullName()]; ok {
+		return nil, nil
+	}
+	if ok, _ := path.Match(pattern, name); !ok {
+		return nil, nil
+	}
+	for _, exclude := range s.exclude {
+		if exclude.match(repo) {
+			return nil, nil
+		}
+	}
+
+	url, err := remote.GetCloneURL(repo)
+	if err != nil {
+		return nil, errors.Trace(err, "get clone url of %s", repo.FullName())
+	}
+	env := make(osutil.Env)
+	err = repo.SetEnv(remote, env)
+	if err != nil {
+		return nil, errors.Trace(err, "set repo env")
+	}
+	selected[repo.FullName()] = struct{}{}
+	return &WorkflowMatchItem{
+		Env:    env,
+		Repo:   repo,
+		Clone:  url,
+		remote: remote,
+	}, nil
+}
+
+// remoteGroup returns the group to list repos and the glob to match names.
+// For example, "myorg/api-*" lists "myorg", "myorg" lists all its repos.
+func (m *workflowRepoMatch) remoteGroup() (string, string) {
+	pattern := strings.Trim(m.pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		return pattern, pattern + "/*"
+	}
+	var group []string
+	for _, part := range strings.Split(pattern, "/") {
+		if strings.ContainsAny(part, "*?[\\") {
+			break
+		}
+		group = append(group, part)
+	}
+	if len(group) == strings.Count(pattern, "/")+1 {
+		// No glob, the last part is repo name.
+		group = group[:len(group)-1]
+	}
+	return strings.Join(group, "/"), pattern
+}
+
+// Name returns the path of item, or the repo name if it is not cloned yet.
+func (item *WorkflowMatchItem) Name() string {
+	if item.Path == "" && item.Repo != nil {
+		return item.Repo.FullName()
+	}
+	return item.Path
+}
+
+// CloneTemp clones the repo selected by RemoteRepos to dir with depth 1, the
+// Path and Env are updated.
+func (item *WorkflowMatchItem) CloneTemp(dir string) error {
+	path := filepath.Join(dir, item.Repo.Remote, item.Repo.Name)
+	err := git.Exec([]string{"clone", "--depth", "1", item.Clone, path}, git.Mute)
+	if err != nil {
+		return errors.Trace(err, "clone %s", item.Repo.FullName())
+	}
+	item.Path = path
+	item.Repo.Path = path
+	return item.Repo.SetEnv(item.remote, item.Env)
+}
+
+// matchItem checks the conditions of the select itself, the cheaper ones
+// are checked first.
+func (s *WorkflowSelect) matchItem(item *WorkflowMatchItem) (bool, error) {
+	if !s.matchSource(item) {
+		return false, nil
+	}
+	for _, m := range s.exclude {
+		if item.Repo != nil && m.match(item.Repo) {
+			return false, nil
+		}
+	}
+	for _, pattern := range s.Exclude {
+		ok, _ := filepath.Match(os.ExpandEnv(pattern), item.Path)
+		if ok {
+			return false, nil
+		}
+	}
+
+	if len(s.Labels) > 0 || s.accessedWithin > 0 {
+		if item.Repo == nil {
+			// The labels and access are only recorded for repos.
+			return false, nil
+		}
+		for _, label := range s.Labels {
+			if !item.Repo.HasLabel(label) {
+				return false, nil
+			}
+		}
+		if s.accessedWithin > 0 {
+			lastAccess := time.Unix(item.Repo.LastAccess, 0)
+			if time.Since(lastAccess) > s.accessedWithin {
+				return false, nil
+			}
+		}
+	}
+
+	if s.Git != nil {
+		return s.Git.match(item)
+	}
+	return true, nil
+}
+
+// matchSource checks whether the item is selected by the sources, no
+// source means all items.
+func (s *WorkflowSelect) matchSource(item *WorkflowMatchItem) bool {
+	if len(s.Repos) == 0 && len(s.Regex) == 0 && len(s.Groups) == 0 &&
+		len(s.Dirs) == 0 && len(s.RemoteRepos) == 0 {
+		return true
+	}
+	for _, dir := range s.dirs {
+		if dir.match(item.Path) {
+			return true
+		}
+	}
+	if item.Repo == nil {
+		return false
+	}
+	for _, m := range s.repos {
+		if m.match(item.Repo) {
+			return true
+		}
+	}
+	for _, m := range s.groups {
+		if m.matchGroup(item.Repo) {
+			return true
+		}
+	}
+	fullName := item.Repo.FullName()
+	for _, re := range s.regex {
+		if re.MatchString(fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *WorkflowGitSelect) match(item *WorkflowMatchItem) (bool, error) {
+	if item.git == nil {
+		item.git = new(workflowGitState)
+	}
+	opts := &git.Options{
+		QuietCmd:    true,
+		QuietStderr: true,
+		Path:        item.Path,
+	}
+
+	if len(s.Files) > 0 {
+		var found bool
+		for _, pattern := range s.Files {
+			matches, _ := filepath.Glob(filepath.Join(item.Path, pattern))
+			if len(matches) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if s.Dirty != nil {
+		if item.git.dirty == nil {
+			changes, err := git.OutputItems([]string{"status", "-s"}, opts)
+			if err != nil {
+				return false, errors.Trace(err, "get git status of %s", item.Path)
+			}
+			dirty := len(changes) > 0
+			item.git.dirty = &dirty
+		}
+		if *item.git.dirty != *s.Dirty {
+			return false, nil
+		}
+	}
+
+	if len(s.Branch) > 0 {
+		if item.git.branch == nil {
+			branch, err := git.GetCurrentBranch(opts)
+			if err != nil {
+				return false, errors.Trace(err, "get current branch of %s", item.Path)
+			}
+			item.git.branch = &branch
+		}
+		for _, pattern := range s.Branch {
+			ok, _ := path.Match(pattern, *item.git.branch)
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// resolveDirs checks the Dirs of select and sub selectors, returns the git
+// directories found.
+func (s *WorkflowSelect) resolveDirs() ([]string, error) {
+	s.dirs = make([]*workflowDir, len(s.Dirs))
+	var paths []string
+	for i, dir := range s.Dirs {
+		var scan bool
+		if strings.HasSuffix(dir, "*") {
+			scan = true
+			dir = strings.TrimSuffix(dir, "*")
+		}
+		dir = os.ExpandEnv(dir)
+		exists, err := osutil.DirExists(dir)
+		if err != nil {
+			return nil, errors.Trace(err, "check dir exists")
+		}
+		if !exists {
+			return nil, fmt.Errorf("%s is not exists", dir)
+		}
+		dir = strings.TrimSuffix(dir, "/")
+		s.dirs[i] = &workflowDir{path: dir, scan: scan}
+		if !scan {
+			err = git.EnsurePath(dir)
+			if err != nil {
+				if err == git.ErrNotGit {
+					return nil, fmt.Errorf("%s is not a git repository", dir)
+				}
+				return nil, errors.Trace(err, "check git dir")
+			}
+			paths = append(paths, dir)
+			continue
+		}
+		items, err := git.Discover(dir)
+		if err != nil {
+			return nil, errors.Trace(err, "scan git dir")
+		}
+		for _, item := range items {
+			paths = append(paths, item.Path)
+		}
+	}
+	for _, sub := range append(s.Any, s.All...) {
+		subPaths, err := sub.resolveDirs()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, subPaths...)
+	}
+	return paths, nil
+}
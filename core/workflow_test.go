@@ -0,0 +1,632 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fioncat/gitzombie/config"
+	"github.com/fioncat/gitzombie/pkg/osutil"
+	"gopkg.in/yaml.v3"
+)
+
+type resolveInputsCase struct {
+	name   string
+	params map[string]string
+	stdin  string
+	expect osutil.Env
+	err    bool
+}
+
+func TestWorkflowInputs(t *testing.T) {
+	var wf Workflow
+	err := yaml.Unmarshal([]byte(`
+inputs:
+  - name: go-version
+    description: The go version to upgrade
+    required: true
+  - name: channel
+    default: stable
+    enum: [stable, beta]
+  - name: message
+jobs:
+  - name: upgrade
+    run: go mod edit -go=${INPUT_GO_VERSION}
+`), &wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []resolveInputsCase{
+		{
+			name:   "default",
+			params: map[string]string{"go-version": "1.20"},
+			expect: osutil.Env{
+				"INPUT_GO_VERSION": "1.20",
+				"INPUT_CHANNEL":    "stable",
+				"INPUT_MESSAGE":    "",
+			},
+		},
+		{
+			name:   "invalid enum",
+			params: map[string]string{"go-version": "1.20", "channel": "nightly"},
+			err:    true,
+		},
+		{
+			name:   "unknown input",
+			params: map[string]string{"go-version": "1.20", "unknown": "value"},
+			err:    true,
+		},
+		{
+			// The missing required input is asked.
+			name:   "ask required",
+			params: map[string]string{"channel": "beta"},
+			stdin:  "1.21\n",
+			expect: osutil.Env{
+				"INPUT_GO_VERSION": "1.21",
+				"INPUT_CHANNEL":    "beta",
+				"INPUT_MESSAGE":    "",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			testResolveInputs(t, &wf, c)
+		})
+	}
+
+	wf.Inputs[1].Default = "nightly"
+	if wf.Validate() == nil {
+		t.Fatal("expect error for invalid default value")
+	}
+}
+
+func testResolveInputs(t *testing.T, wf *Workflow, c resolveInputsCase) {
+	if c.stdin != "" {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin := os.Stdin
+		os.Stdin = r
+		defer func() { os.Stdin = stdin }()
+		_, err = w.WriteString(c.stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	env, err := wf.ResolveInputs(c.params)
+	if c.err {
+		if err == nil {
+			t.Fatal("expect error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(env, c.expect) {
+		t.Fatalf("unexpected env %v", env)
+	}
+}
+
+func TestWorkflowInputEnum(t *testing.T) {
+	// The enum values can contain any characters.
+	input := &WorkflowInput{Name: "target", Enum: []string{"release candidate", "a,b", `it's "ok"`}}
+	for _, value := range append(input.Enum, "") {
+		err := input.validate(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if input.validate("a") == nil {
+		t.Fatal("expect error for value not in enum")
+	}
+}
+
+func TestWorkflowReport(t *testing.T) {
+	MuteJob = true
+	defer func() { MuteJob = false }()
+
+	root := t.TempDir()
+	run := &JobRun{
+		Jobs: []*Job{
+			{Name: "hello", Run: "echo hello"},
+			{Name: "skip", RequireEnv: []string{"UNKNOWN_ENV"}},
+			{Name: "fail", Run: "echo oops; exit 3"},
+			{Name: "never", Run: "echo never"},
+		},
+		Root: root,
+	}
+	err := run.Execute()
+	if err == nil {
+		t.Fatal("expect error")
+	}
+
+	expect := []struct {
+		status   JobStatus
+		exitCode int
+		output   string
+	}{
+		{JobSuccess, 0, "hello\n"},
+		{JobSkipped, 0, ""},
+		{JobFailure, 3, "oops\n"},
+		{JobSkipped, 0, ""},
+	}
+	for i, e := range expect {
+		report := run.Reports[i]
+		if report.Status != e.status || report.ExitCode != e.exitCode || report.Output != e.output {
+			t.Fatalf("unexpected report of %s: %+v", report.Name, report)
+		}
+	}
+
+	for _, report := range run.Reports {
+		report.Duration = 0
+	}
+	report := &WorkflowReport{
+		Workflow: "test",
+		Repos: []*RepoReport{
+			NewRepoReport(&WorkflowMatchItem{Path: root}, run, 0, err),
+		},
+	}
+	if report.Failed() != 1 {
+		t.Fatal("expect one failed repo")
+	}
+	if summary := report.Repos[0].JobsSummary(); summary != "1/4 success, failed: fail" {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+
+	paths, err := report.Write(filepath.Join(root, "report"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+	data, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "| fail | failure | 0s | 3 | 1 |") {
+		t.Fatalf("unexpected markdown:\n%s", data)
+	}
+}
+
+func TestWorkflowSelect(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now().Unix()
+	repos := []*Repository{
+		{Remote: "github", Name: "fioncat/gitzombie", LastAccess: now - 60, Access: 10, Labels: []string{"go", "tool"}},
+		{Remote: "github", Name: "fioncat/dotfiles", LastAccess: now - 60, Access: 1},
+		{Remote: "github", Name: "golang/go", LastAccess: now - 30*24*3600, Access: 100, Labels: []string{"go"}},
+		{Remote: "gitlab", Name: "fioncat/infra/notes", LastAccess: now - 3600*2, Access: 5},
+	}
+	items := make([]*WorkflowMatchItem, len(repos))
+	for i, repo := range repos {
+		repo.Path = filepath.Join(root, repo.Remote, repo.Name)
+		err := repo.normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = &WorkflowMatchItem{Path: repo.Path, Repo: repo}
+	}
+	cases := []struct {
+		select_ string
+		expect  []string
+	}{
+		{`repos: [github]`, []string{"github:fioncat/gitzombie", "github:fioncat/dotfiles", "github:golang/go"}},
+		{`repos: ["github:fioncat/*"]`, []string{"github:fioncat/gitzombie", "github:fioncat/dotfiles"}},
+		{`repos: ["github:*/go", "gitlab:"]`, []string{"github:golang/go", "gitlab:fioncat/infra/notes"}},
+		{`repos: ["github:unknown/*"]`, nil},
+		{`{repos: [github], exclude: ["github:fioncat/dotfiles"]}`, []string{"github:fioncat/gitzombie", "github:golang/go"}},
+		{`regex: ["^git(hub|lab):fioncat/"]`, []string{"github:fioncat/gitzombie", "github:fioncat/dotfiles", "gitlab:fioncat/infra/notes"}},
+		{`groups: ["gitlab:fioncat"]`, []string{"gitlab:fioncat/infra/notes"}},
+		{`labels: [go]`, []string{"github:fioncat/gitzombie", "github:golang/go"}},
+		{`labels: [go, tool]`, []string{"github:fioncat/gitzombie"}},
+		{`accessed_within: 1w`, []string{"github:fioncat/gitzombie", "github:fioncat/dotfiles", "gitlab:fioncat/infra/notes"}},
+		{`top: 2`, []string{"github:fioncat/gitzombie", "github:golang/go"}},
+		{`{any: [{labels: [tool]}, {groups: ["gitlab:fioncat/infra"]}]}`, []string{"github:fioncat/gitzombie", "gitlab:fioncat/infra/notes"}},
+		{`{repos: [github], all: [{labels: [go]}, {accessed_within: 1h}]}`, []string{"github:fioncat/gitzombie"}},
+	}
+	for _, c := range cases {
+		var s WorkflowSelect
+		err := yaml.Unmarshal([]byte(c.select_), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched, err := s.match(items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, item := range matched {
+			names = append(names, item.Repo.FullName())
+		}
+		if !reflect.DeepEqual(names, c.expect) {
+			t.Fatalf("%s: expect %v, found %v", c.select_, c.expect, names)
+		}
+	}
+
+	for _, select_ := range []string{
+		`repos: [":fioncat/*"]`,
+		`regex: ["("]`,
+		`groups: [github]`,
+		`accessed_within: 3x`,
+		`git: {branch: ["["]}`,
+	} {
+		var s WorkflowSelect
+		err := yaml.Unmarshal([]byte(select_), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.validate() == nil {
+			t.Fatalf("%s: expect error", select_)
+		}
+	}
+}
+
+// initTestRepo creates a git repository at dir with one empty commit on
+// branch main.
+func initTestRepo(t *testing.T, dir string) {
+	t.Helper()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main", dir},
+		{"-C", dir, "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v, %s", args, err, out)
+		}
+	}
+}
+
+func TestWorkflowSelectGit(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"clean", "dirty", "feature"} {
+		initTestRepo(t, filepath.Join(root, name))
+	}
+	err := os.WriteFile(filepath.Join(root, "dirty", "go.mod"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.Command("git", "-C", filepath.Join(root, "feature"), "checkout", "-q", "-b", "feature/select").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		select_ string
+		expect  []string
+	}{
+		{`dirs: [` + root + `/*]`, []string{"clean", "dirty", "feature"}},
+		{`{dirs: [` + root + `/*], exclude: ["` + root + `/f*"]}`, []string{"clean", "dirty"}},
+		{`{dirs: [` + root + `/*], git: {dirty: true}}`, []string{"dirty"}},
+		{`{dirs: [` + root + `/*], git: {dirty: false, branch: [main]}}`, []string{"clean"}},
+		{`{dirs: [` + root + `/*], git: {branch: ["feature/*"]}}`, []string{"feature"}},
+		{`{dirs: [` + root + `/*], git: {files: ["*.mod"]}}`, []string{"dirty"}},
+	}
+	store := &RepositoryStorage{}
+	for _, c := range cases {
+		var s WorkflowSelect
+		err = yaml.Unmarshal([]byte(c.select_), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := s.Match(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, item := range items {
+			names = append(names, filepath.Base(item.Path))
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, c.expect) {
+			t.Fatalf("%s: expect %v, found %v", c.select_, c.expect, names)
+		}
+	}
+}
+
+// writeTestRemote writes the remote "github" for tests.
+func writeTestRemote(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteDir := config.GetDir("remotes")
+	err = osutil.EnsureDir(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(remoteDir, "github.toml"),
+		[]byte("host = \"github.com\"\nprotocol = \"https\"\nuser = \"test\"\nemail = \"test@test.com\"\nprovider = \"github\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listTestRemoteRepos(remote *Remote, group string) ([]string, error) {
+	if remote.Name != "github" || group != "myorg" {
+		return nil, fmt.Errorf("unexpected list %s:%s", remote.Name, group)
+	}
+	return []string{"myorg/api", "myorg/api-docs", "myorg/web", "myorg/legacy"}, nil
+}
+
+type selectRemoteCase struct {
+	select_ string
+	expect  []string
+	err     bool
+}
+
+func TestWorkflowSelectRemote(t *testing.T) {
+	writeTestRemote(t)
+	local := &Repository{Remote: "github", Name: "myorg/web", Path: "/src/myorg/web"}
+	err := local.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []*WorkflowMatchItem{{Path: local.Path, Repo: local}}
+
+	cases := []selectRemoteCase{
+		{select_: `remote_repos: ["github:myorg"]`, expect: []string{"myorg/api", "myorg/api-docs", "myorg/legacy"}},
+		{select_: `remote_repos: ["github:myorg/api*"]`, expect: []string{"myorg/api", "myorg/api-docs"}},
+		{select_: `remote_repos: ["github:myorg/api"]`, expect: []string{"myorg/api"}},
+		{select_: `{remote_repos: ["github:myorg/*"], exclude: ["github:myorg/legacy"]}`, expect: []string{"myorg/api", "myorg/api-docs"}},
+
+		{select_: `remote_repos: ["github:"]`, err: true},
+		{select_: `remote_repos: ["github:*"]`, err: true},
+		{select_: `remote_repos: ["github:my*/api"]`, err: true},
+		{select_: `any: [{remote_repos: ["github:myorg"]}]`, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.select_, func(t *testing.T) {
+			testWorkflowSelectRemote(t, c, items)
+		})
+	}
+}
+
+func testWorkflowSelectRemote(t *testing.T, c selectRemoteCase, items []*WorkflowMatchItem) {
+	var s WorkflowSelect
+	err := yaml.Unmarshal([]byte(c.select_), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.validate()
+	if c.err {
+		if err == nil {
+			t.Fatal("expect error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matched, err := s.match(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 0 {
+		t.Fatalf("expect no local repo, found %d", len(matched))
+	}
+	remoteItems, err := s.MatchRemote(items, listTestRemoteRepos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range remoteItems {
+		names = append(names, item.Repo.Name)
+		url := "https://github.com/" + item.Repo.Name + ".git"
+		if item.Clone != url || item.Path != "" || item.Env["REPO_NAME"] != item.Repo.Name {
+			t.Fatalf("unexpected item %+v", item)
+		}
+	}
+	if !reflect.DeepEqual(names, c.expect) {
+		t.Fatalf("expect %v, found %v", c.expect, names)
+	}
+}
+
+func TestWorkflowSelectClone(t *testing.T) {
+	writeTestRemote(t)
+	origin := filepath.Join(t.TempDir(), "origin")
+	initTestRepo(t, origin)
+	var s WorkflowSelect
+	s.RemoteRepos = []string{"github:myorg/api"}
+	remoteItems, err := s.MatchRemote(nil, listTestRemoteRepos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := remoteItems[0]
+	item.Clone = "file://" + origin
+	dir := t.TempDir()
+	err = item.CloneTemp(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := filepath.Join(dir, "github", "myorg", "api")
+	if item.Path != expect || item.Env["REPO_PATH"] != expect {
+		t.Fatalf("unexpected clone path %q", item.Path)
+	}
+	_, err = os.Stat(filepath.Join(expect, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobRunPlan(t *testing.T) {
+	root := t.TempDir()
+	jobs := []*Job{
+		{Name: "lint", Run: "golangci-lint run ${INPUT_DIR}"},
+		{Name: "publish", RequireEnv: []string{"TOKEN"}, Run: "publish"},
+		{Name: "test", Command: []string{"go", "test", "$INPUT_DIR"}, Workdir: "src"},
+		{Name: "cargo", If: `exists("Cargo.toml")`, Run: "cargo build"},
+		{Name: "notify", If: `result("lint") == "success"`, Shell: "python3", Run: "print('done')"},
+		{Name: "echo", Command: []string{"echo", "${INPUT_DIR}", "$HOME", "$1"}},
+	}
+	err := validateJobs(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &JobRun{
+		Jobs: jobs,
+		Root: root,
+		Env:  osutil.Env{"INPUT_DIR": "./..."},
+	}
+	expect := []*JobPlan{
+		{Name: "lint", Dir: root, Args: []string{"bash", "-c", "golangci-lint run ${INPUT_DIR}"}},
+		{Name: "publish", Dir: root, Skip: `unbound env "TOKEN"`},
+		{Name: "test", Dir: filepath.Join(root, "src"), Args: []string{"go", "test", "./..."}},
+		{Name: "cargo", Dir: root, Skip: `if exists("Cargo.toml") is false`},
+		{Name: "notify", Dir: root, Args: []string{"python3", "-c", "print('done')"}},
+		// The system env is not printed.
+		{Name: "echo", Dir: root, Args: []string{"echo", "./...", "$HOME", "$1"}},
+	}
+	plans := run.Plan()
+	for i, plan := range plans {
+		if !reflect.DeepEqual(plan, expect[i]) {
+			t.Fatalf("expect %+v, found %+v", expect[i], plan)
+		}
+	}
+
+	// The repo is not cloned yet, the "if" is pending.
+	run.Root = ""
+	plan := run.Plan()[3]
+	if plan.Skip != "" || plan.Pending != `exists("Cargo.toml")` {
+		t.Fatalf("expect pending if, found %+v", plan)
+	}
+}
+
+func TestWorkflowHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, 3, 15, 3, 0, 0, 0, time.UTC)
+	for i, name := range []string{"nightly", "gc", "nightly"} {
+		err = AppendWorkflowHistory(&WorkflowHistory{
+			Workflow: name,
+			Trigger:  TriggerSchedule,
+			Start:    start.Add(time.Hour * time.Duration(i)),
+			Repos:    2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	histories, err := ListWorkflowHistory("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || !histories[0].Start.Equal(start.Add(time.Hour*2)) {
+		t.Fatalf("unexpected histories %+v", histories)
+	}
+	histories, err = ListWorkflowHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 3 {
+		t.Fatalf("unexpected histories %+v", histories)
+	}
+
+	// The run missed during sleep is caught up once.
+	wf := &Workflow{
+		Schedule: "0 3 * * *",
+		Jobs:     []*Job{{Name: "fetch", Run: "git fetch"}},
+	}
+	err = wf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{start: start, last: make(map[string]time.Time)}
+	last, err := d.lastRun("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := wf.schedule.Next(last)
+	if expect := start.AddDate(0, 0, 1); !next.Equal(expect) {
+		t.Fatalf("expect next run %v, found %v", expect, next)
+	}
+}
+
+func TestWorkflowLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := LockWorkflow("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LockWorkflow("nightly")
+	if err == nil {
+		t.Fatal("expect error for locked workflow")
+	}
+	unlock()
+	unlock, err = LockWorkflow("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestWorkflowSchedule(t *testing.T) {
+	jobs := []*Job{{Name: "fetch", Run: "git fetch"}}
+	cases := []struct {
+		name     string
+		schedule string
+		inputs   []*WorkflowInput
+		err      bool
+	}{
+		{name: "valid", schedule: "0 3 * * *"},
+		{name: "invalid", schedule: "0 3 * *", err: true},
+		{
+			name:     "required input without default",
+			schedule: "0 3 * * *",
+			inputs:   []*WorkflowInput{{Name: "version", Required: true}},
+			err:      true,
+		},
+		{
+			name:     "required input with default",
+			schedule: "0 3 * * *",
+			inputs:   []*WorkflowInput{{Name: "version", Required: true, Default: "latest"}},
+		},
+		{
+			// The manual run asks the required input.
+			name:   "no schedule",
+			inputs: []*WorkflowInput{{Name: "version", Required: true}},
+		},
+	}
+	for _, c := range cases {
+		wf := &Workflow{Schedule: c.schedule, Inputs: c.inputs, Jobs: jobs}
+		err := wf.Validate()
+		if c.err && err == nil {
+			t.Fatalf("%s: expect error", c.name)
+		}
+		if !c.err && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+}
@@ -13,7 +13,9 @@ require (
 	github.com/spf13/cobra v1.6.1
 	github.com/vbauerster/mpb/v8 v8.2.0
 	github.com/xanzy/go-gitlab v0.80.2
+	golang.org/x/crypto v0.5.0
 	golang.org/x/oauth2 v0.5.0
+	golang.org/x/sys v0.5.0
 	golang.org/x/term v0.5.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -35,9 +37,7 @@ require (
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/rivo/uniseg v0.4.3 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/crypto v0.5.0 // indirect
 	golang.org/x/net v0.6.0 // indirect
-	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 	golang.org/x/time v0.3.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
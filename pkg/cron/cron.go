@@ -0,0 +1,210 @@
+// Package cron parses the standard cron expression with 5 fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field supports "*", numbers, ranges ("1-5"), lists ("1,3") and steps
+// ("*/15", "1-30/5"). Month and day-of-week also accept names, such as
+// "jan" and "mon". The descriptors "@yearly", "@monthly", "@weekly",
+// "@daily" ("@midnight") and "@hourly" are supported too.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule struct {
+	expr string
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// Same as crontab, if both day fields are restricted (not start with
+	// "*"), the day matches when either field matches.
+	domStar bool
+	dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type field struct {
+	name string
+
+	min int
+	max int
+
+	names []string
+}
+
+var (
+	minuteField = &field{name: "minute", min: 0, max: 59}
+	hourField   = &field{name: "hour", min: 0, max: 23}
+	domField    = &field{name: "day of month", min: 1, max: 31}
+	monthField  = &field{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun",
+		"jul", "aug", "sep", "oct", "nov", "dec",
+	}}
+	// Both 0 and 7 are Sunday.
+	dowField = &field{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}}
+)
+
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		spec, ok = descriptors[spec]
+		if !ok {
+			return nil, fmt.Errorf("unknown cron descriptor %q", expr)
+		}
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron %q, expect 5 fields, found %d", expr, len(fields))
+	}
+
+	s := &Schedule{
+		expr:    expr,
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field *field
+	}{
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		*f.bits, err = f.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q: %v", expr, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+func (f *field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepStr, f.name)
+			}
+		}
+
+		var low, high int
+		if rangeStr == "*" {
+			low, high = f.min, f.max
+		} else {
+			lowStr, highStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			low, err = f.value(lowStr)
+			if err != nil {
+				return 0, err
+			}
+			high = low
+			switch {
+			case isRange:
+				high, err = f.value(highStr)
+				if err != nil {
+					return 0, err
+				}
+
+			case hasStep:
+				// "5/15" means "5-max/15".
+				high = f.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q in %s", rangeStr, f.name)
+		}
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (f *field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			if f.min == 1 {
+				return i + 1, nil
+			}
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s, should be in [%d, %d]", s, f.name, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the next time matching the schedule after t, in t's location.
+// Zero time is returned if there is no matching time in 5 years, such as
+// "0 0 30 2 *".
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		if s.month&(1<<uint(month)) == 0 {
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
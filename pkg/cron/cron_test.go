@@ -0,0 +1,70 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// 2023-03-15 is Wednesday.
+	now := time.Date(2023, 3, 15, 10, 30, 20, 0, time.UTC)
+	cases := []struct {
+		expr   string
+		expect string
+	}{
+		{"* * * * *", "2023-03-15 10:31"},
+		{"*/15 * * * *", "2023-03-15 10:45"},
+		{"0 3 * * *", "2023-03-16 03:00"},
+		{"@daily", "2023-03-16 00:00"},
+		{"@hourly", "2023-03-15 11:00"},
+		{"30 10 * * *", "2023-03-16 10:30"},
+		{"0 9-17/4 * * mon-fri", "2023-03-15 13:00"},
+		{"0 0 * * sun", "2023-03-19 00:00"},
+		{"0 0 * * 7", "2023-03-19 00:00"},
+		{"0 0 1 * *", "2023-04-01 00:00"},
+		{"0 0 1,20 jan,mar *", "2023-03-20 00:00"},
+		// Either day of month or day of week matches.
+		{"0 0 31 * fri", "2023-03-17 00:00"},
+		{"0 0 29 2 *", "2024-02-29 00:00"},
+		{"5/20 * * * *", "2023-03-15 10:45"},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := s.Next(now).Format("2006-01-02 15:04")
+		if next != c.expect {
+			t.Fatalf("%s: expect %s, found %s", c.expr, c.expect, next)
+		}
+	}
+
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Next(now).IsZero() {
+		t.Fatal("expect zero time for impossible schedule")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@reboot",
+	} {
+		_, err := Parse(expr)
+		if err == nil {
+			t.Fatalf("expect error for %q", expr)
+		}
+	}
+}
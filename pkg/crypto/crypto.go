@@ -6,12 +6,11 @@ import (
 	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
-	"math/rand"
-	"time"
-	"unsafe"
 
 	"github.com/fioncat/gitzombie/pkg/errors"
+	"golang.org/x/crypto/argon2"
 )
 
 var (
@@ -32,29 +31,105 @@ func initGCM(key []byte) (cipher.AEAD, error) {
 	return gcm, errors.Trace(err, "init gcm")
 }
 
-func encodePassword(password, salt string) []byte {
-	if salt != "" {
-		password += salt
+// KDF is the parameters to derive key from password. It is stored alongside
+// the encrypted value, so that the parameters can be changed in the future
+// without breaking old values.
+type KDF struct {
+	Algorithm string `yaml:"algorithm" json:"algorithm"`
+
+	// The argon2id parameters, memory is in KiB.
+	Time    uint32 `yaml:"time" json:"time"`
+	Memory  uint32 `yaml:"memory" json:"memory"`
+	Threads uint8  `yaml:"threads" json:"threads"`
+}
+
+const argon2id = "argon2id"
+
+// DefaultKDF returns the recommended argon2id parameters, see:
+// https://www.rfc-editor.org/rfc/rfc9106.html#section-4
+func DefaultKDF() *KDF {
+	return &KDF{
+		Algorithm: argon2id,
+		Time:      3,
+		Memory:    64 * 1024,
+		Threads:   4,
+	}
+}
+
+func (kdf *KDF) Equal(o *KDF) bool {
+	if kdf == nil || o == nil {
+		return kdf == o
+	}
+	return *kdf == *o
+}
+
+// The upper bounds of kdf parameters. The parameters might come from an
+// untrusted file, so they must be limited to avoid exhausting memory or cpu.
+const (
+	maxKDFTime   = 10
+	maxKDFMemory = 1024 * 1024
+)
+
+func (kdf *KDF) validate() error {
+	if kdf.Algorithm != argon2id {
+		return fmt.Errorf("unsupported kdf algorithm %q", kdf.Algorithm)
+	}
+	if kdf.Time == 0 || kdf.Threads == 0 {
+		return errors.New("invalid kdf parameters, time and threads cannot be zero")
+	}
+	if kdf.Memory < 8*uint32(kdf.Threads) {
+		return errors.New("invalid kdf parameters, memory is too small")
+	}
+	if kdf.Time > maxKDFTime {
+		return fmt.Errorf("invalid kdf parameters, time cannot be greater than %d", maxKDFTime)
+	}
+	if kdf.Memory > maxKDFMemory {
+		return fmt.Errorf("invalid kdf parameters, memory cannot be greater than %d KiB", maxKDFMemory)
+	}
+	return nil
+}
+
+// deriveKey derives the aes key from password. If kdf is nil, use the legacy
+// sha256 to keep compatible with values encrypted by old versions.
+func deriveKey(password, salt string, kdf *KDF) ([]byte, error) {
+	if kdf == nil {
+		sum := sha256.Sum256([]byte(password + salt))
+		return sum[:], nil
+	}
+	err := kdf.validate()
+	if err != nil {
+		return nil, err
 	}
+	return argon2.IDKey([]byte(password), []byte(salt), kdf.Time, kdf.Memory, kdf.Threads, 32), nil
+}
 
-	sum := sha256.Sum256([]byte(password))
-	return sum[:32]
+// EncryptKDF encrypts data with a random salt, the key is derived by kdf.
+func EncryptKDF(password string, data []byte, kdf *KDF) (string, string, error) {
+	if kdf == nil {
+		// Never produce new values with the legacy sha256 key.
+		return "", "", errors.New("kdf is required to encrypt")
+	}
+	salt, err := genSalt()
+	if err != nil {
+		return "", "", err
+	}
+	value, err := encrypt(password, salt, data, kdf)
+	return value, salt, err
 }
 
-func Encrypt(password string, data []byte, noSalt bool) (string, string, error) {
+func encrypt(password, salt string, data []byte, kdf *KDF) (string, error) {
 	if password == "" {
-		return "", "", ErrPasswordEmpty
+		return "", ErrPasswordEmpty
 	}
 
-	var salt string
-	if !noSalt {
-		salt = genSalt()
+	key, err := deriveKey(password, salt, kdf)
+	if err != nil {
+		return "", err
 	}
-	key := encodePassword(password, salt)
 
 	gcm, err := initGCM(key)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
 	// creates a new byte array the size of the nonce which must be passed to Seal
@@ -63,19 +138,30 @@ func Encrypt(password string, data []byte, noSalt bool) (string, string, error)
 	// populates our nonce with a cryptographically secure random sequence
 	_, err = io.ReadFull(crand.Reader, nonce)
 	if err != nil {
-		return "", "", errors.Trace(err, "generate random sequence")
+		return "", errors.Trace(err, "generate random sequence")
 	}
 
 	result := gcm.Seal(nonce, nonce, data, nil)
-	return hex.EncodeToString(result), salt, nil
+	return hex.EncodeToString(result), nil
 }
 
-func Decrypt(password, salt, value string) ([]byte, error) {
+// decryptLegacy decrypts the value encrypted by old versions, whose key is
+// the sha256 of password and salt.
+func decryptLegacy(password, salt, value string) ([]byte, error) {
+	return DecryptKDF(password, salt, value, nil)
+}
+
+// DecryptKDF decrypts the value, the key is derived by kdf. The nil kdf is
+// for the legacy values encrypted by old versions.
+func DecryptKDF(password, salt, value string, kdf *KDF) ([]byte, error) {
 	if password == "" {
 		return nil, ErrPasswordEmpty
 	}
 
-	key := encodePassword(password, salt)
+	key, err := deriveKey(password, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
 
 	gcm, err := initGCM(key)
 	if err != nil {
@@ -102,34 +188,13 @@ func Decrypt(password, salt, value string) ([]byte, error) {
 	return result, nil
 }
 
-var (
-	saltSrc = rand.NewSource(time.Now().UnixNano())
-)
-
-const (
-	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+const saltLen = 16
 
-	saltLen = 16
-
-	letterIdxBits = 6                    // 6 bits to represent a letter index
-	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-)
-
-func genSalt() string {
+func genSalt() (string, error) {
 	b := make([]byte, saltLen)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := saltLen-1, saltSrc.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = saltSrc.Int63(), letterIdxMax
-		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
-		}
-		cache >>= letterIdxBits
-		remain--
-	}
-
-	return *(*string)(unsafe.Pointer(&b))
+	_, err := io.ReadFull(crand.Reader, b)
+	if err != nil {
+		return "", errors.Trace(err, "generate random salt")
+	}
+	return hex.EncodeToString(b), nil
 }
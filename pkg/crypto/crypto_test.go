@@ -1,7 +1,9 @@
 package crypto
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -28,12 +30,17 @@ func TestCrypto(t *testing.T) {
 		},
 	}
 	for _, testCase := range testCases {
-		encrypted, salt, err := Encrypt(testCase.password, []byte(testCase.value), false)
+		// The legacy values can only be encrypted inside the package.
+		salt, err := genSalt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := encrypt(testCase.password, salt, []byte(testCase.value), nil)
 		if err != nil {
 			t.Fatal(err)
 		}
 		fmt.Printf("encrypted: %s, salt: %s\n", encrypted, salt)
-		raw, err := Decrypt(testCase.password, salt, encrypted)
+		raw, err := decryptLegacy(testCase.password, salt, encrypted)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -42,9 +49,169 @@ func TestCrypto(t *testing.T) {
 		}
 
 		badPassword := "bad password"
-		_, err = Decrypt(badPassword, salt, encrypted)
+		_, err = decryptLegacy(badPassword, salt, encrypted)
 		if err != ErrIncorrectPassword {
 			t.Fatal("expect incorrect password")
 		}
 	}
 }
+
+func TestKDF(t *testing.T) {
+	kdf := DefaultKDF()
+	// Use small memory to speed up test.
+	kdf.Memory = 1024
+	encrypted, salt, err := EncryptKDF("test123", []byte("hello"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(salt) != saltLen*2 {
+		t.Fatalf("unexpected salt %q", salt)
+	}
+
+	raw, err := DecryptKDF("test123", salt, encrypted, kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "hello" {
+		t.Fatalf("incorrect decrypt result: %q", raw)
+	}
+
+	_, err = DecryptKDF("bad password", salt, encrypted, kdf)
+	if err != ErrIncorrectPassword {
+		t.Fatal("expect incorrect password")
+	}
+
+	// The legacy sha256 key cannot decrypt the value.
+	_, err = decryptLegacy("test123", salt, encrypted)
+	if err != ErrIncorrectPassword {
+		t.Fatal("expect incorrect password")
+	}
+
+	_, err = DecryptKDF("test123", salt, encrypted, &KDF{Algorithm: "unknown"})
+	if err == nil {
+		t.Fatal("expect unsupported kdf error")
+	}
+
+	_, _, err = EncryptKDF("test123", []byte("hello"), nil)
+	if err == nil {
+		t.Fatal("expect kdf required error")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	encrypted, err := EncryptPassword("test123", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordEncrypted(encrypted) {
+		t.Fatalf("unexpected encrypted data %q", encrypted)
+	}
+	raw, err := DecryptPassword("test123", encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "hello" {
+		t.Fatalf("incorrect decrypt result: %q", raw)
+	}
+	_, err = DecryptPassword("bad password", encrypted)
+	if err != ErrIncorrectPassword {
+		t.Fatal("expect incorrect password")
+	}
+
+	// The legacy data without header.
+	legacy, err := encrypt("test123", "", []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err = DecryptPassword("test123", []byte(legacy+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "hello" {
+		t.Fatalf("incorrect legacy decrypt result: %q", raw)
+	}
+
+	// The hostile kdf parameters in header should be rejected before
+	// deriving key.
+	lines := strings.Split(string(encrypted), "\n")
+	salt := strings.Fields(lines[1])[5]
+	for _, header := range []string{
+		"-> argon2id 3 4294967295 4 " + salt,
+		"-> argon2id 4294967295 65536 4 " + salt,
+		"-> argon2id 3 65536 1000 " + salt,
+	} {
+		lines[1] = header
+		_, err = DecryptPassword("test123", []byte(strings.Join(lines, "\n")))
+		if err == nil || err == ErrIncorrectPassword {
+			t.Fatalf("%s: expect invalid kdf error, found %v", header, err)
+		}
+	}
+}
+
+func generateTestIdentities(t *testing.T, n int) []*Identity {
+	ids := make([]*Identity, n)
+	for i := range ids {
+		id, err := GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func TestRecipients(t *testing.T) {
+	ids := generateTestIdentities(t, 3)
+	alice, bob, eve := ids[0], ids[1], ids[2]
+
+	var recipients []*Recipient
+	for _, id := range []*Identity{alice, bob} {
+		r, err := ParseRecipient(id.Recipient().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	encrypted, err := EncryptRecipients([]byte("deploy token"), recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsRecipientEncrypted(encrypted) {
+		t.Fatal("expect recipient encrypted")
+	}
+	reversed := []*Recipient{recipients[1], recipients[0]}
+	if EncryptedRecipientsTag(encrypted) != RecipientsTag(reversed) {
+		t.Fatal("unexpected recipients tag")
+	}
+
+	for _, id := range []*Identity{alice, bob} {
+		id, err = ParseIdentity("# comment\n" + id.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := DecryptIdentities(encrypted, []*Identity{eve, id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != "deploy token" {
+			t.Fatalf("incorrect decrypt result: %q", raw)
+		}
+	}
+
+	_, err = DecryptIdentities(encrypted, []*Identity{eve})
+	if err != ErrNoIdentity {
+		t.Fatalf("expect no identity error, found %v", err)
+	}
+
+	// Remove bob from recipients, the header is authenticated.
+	lines := bytes.Split(encrypted, []byte("\n"))
+	modified := bytes.Join(append(lines[:2:2], lines[3:]...), []byte("\n"))
+	if bytes.Equal(modified, encrypted) {
+		t.Fatal("expect modified data")
+	}
+	_, err = DecryptIdentities(modified, []*Identity{alice})
+	if err == nil {
+		t.Fatal("expect modified error")
+	}
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+// The password encrypted file stores the salt and kdf parameters in header,
+// so that the key is derived by argon2id:
+//
+//	gitzombie-password/v1
+//	-> argon2id {time} {memory} {threads} {salt}
+//	---
+//	{payload}
+//
+// The file without header is encrypted by old versions, whose key is the
+// unsalted sha256 of password. It can only be decrypted.
+
+const (
+	passwordHeader = "gitzombie-password/v1"
+
+	headerEnd = "---"
+)
+
+// IsPasswordEncrypted checks if data is encrypted by EncryptPassword.
+func IsPasswordEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(passwordHeader+"\n"))
+}
+
+// EncryptPassword encrypts data with password, the key is derived by the
+// default kdf.
+func EncryptPassword(password string, data []byte) ([]byte, error) {
+	kdf := DefaultKDF()
+	value, salt, err := EncryptKDF(password, data, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(passwordHeader + "\n")
+	fmt.Fprintf(&buf, "-> %s %d %d %d %s\n", kdf.Algorithm,
+		kdf.Time, kdf.Memory, kdf.Threads, salt)
+	buf.WriteString(headerEnd + "\n")
+	buf.WriteString(value + "\n")
+	return buf.Bytes(), nil
+}
+
+// DecryptPassword decrypts the data encrypted by EncryptPassword, or the
+// legacy data encrypted by old versions.
+func DecryptPassword(password string, data []byte) ([]byte, error) {
+	if !IsPasswordEncrypted(data) {
+		return decryptLegacy(password, "", strings.TrimSpace(string(data)))
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || lines[2] != headerEnd {
+		return nil, errors.New("encrypted data is bad format")
+	}
+	var (
+		kdf  KDF
+		salt string
+	)
+	_, err := fmt.Sscanf(lines[1], "-> %s %d %d %d %s", &kdf.Algorithm,
+		&kdf.Time, &kdf.Memory, &kdf.Threads, &salt)
+	if err != nil {
+		return nil, errors.New("encrypted kdf is bad format")
+	}
+	return DecryptKDF(password, salt, lines[3], &kdf)
+}
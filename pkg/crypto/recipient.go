@@ -0,0 +1,316 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The recipient encryption is similar to age (https://age-encryption.org):
+// data is encrypted by a random file key, and the file key is wrapped for
+// each recipient by the X25519 shared secret with an ephemeral key. Anyone
+// who holds one of the recipients' private key can decrypt it.
+//
+// The encrypted format is text, so that it can be committed to git:
+//
+//	gitzombie-encrypted/v1
+//	-> X25519 {ephemeral public key} {wrapped file key}
+//	-> X25519 ...
+//	-> tag {recipients tag}
+//	---
+//	{payload}
+
+const (
+	recipientHeader = "gitzombie-encrypted/v1"
+	stanzaPrefix    = "-> X25519 "
+	tagPrefix       = "-> tag "
+
+	publicKeyPrefix  = "gitzombie-x25519-pub:"
+	privateKeyPrefix = "GITZOMBIE-X25519-KEY:"
+
+	wrapInfo = "gitzombie-x25519"
+
+	fileKeySize = 32
+)
+
+var (
+	ErrNoIdentity = errors.New("no identity can decrypt the data")
+
+	b64 = base64.RawStdEncoding
+)
+
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, errors.Trace(err, "generate x25519 key")
+	}
+	return &Identity{key: key}, nil
+}
+
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{key: id.key.PublicKey()}
+}
+
+func (id *Identity) String() string {
+	return privateKeyPrefix + b64.EncodeToString(id.key.Bytes())
+}
+
+func (r *Recipient) String() string {
+	return publicKeyPrefix + b64.EncodeToString(r.key.Bytes())
+}
+
+// ParseRecipient parses the public key generated by "gz secret keygen",
+// lines starting with "#" are ignored.
+func ParseRecipient(s string) (*Recipient, error) {
+	data, err := parseKey(s, publicKeyPrefix)
+	if err != nil {
+		return nil, errors.Trace(err, "parse public key")
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, errors.Trace(err, "parse public key")
+	}
+	return &Recipient{key: key}, nil
+}
+
+func ParseIdentity(s string) (*Identity, error) {
+	data, err := parseKey(s, privateKeyPrefix)
+	if err != nil {
+		return nil, errors.Trace(err, "parse private key")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, errors.Trace(err, "parse private key")
+	}
+	return &Identity{key: key}, nil
+}
+
+func parseKey(s, prefix string) ([]byte, error) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		encoded, ok := strings.CutPrefix(line, prefix)
+		if !ok {
+			return nil, fmt.Errorf("key should start with %q", prefix)
+		}
+		data, err := b64.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("key is not valid base64")
+		}
+		return data, nil
+	}
+	return nil, errors.New("key is empty")
+}
+
+// IsRecipientString reports whether s is a public key string rather than a
+// file path.
+func IsRecipientString(s string) bool {
+	return strings.HasPrefix(s, publicKeyPrefix)
+}
+
+// IsRecipientEncrypted reports whether the data is encrypted by
+// EncryptRecipients.
+func IsRecipientEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(recipientHeader+"\n"))
+}
+
+func EncryptRecipients(data []byte, recipients []*Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one recipient is required")
+	}
+	fileKey := make([]byte, fileKeySize)
+	_, err := io.ReadFull(crand.Reader, fileKey)
+	if err != nil {
+		return nil, errors.Trace(err, "generate file key")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(recipientHeader + "\n")
+	for _, r := range recipients {
+		ephemeral, wrapped, err := r.wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s%s %s\n", stanzaPrefix,
+			b64.EncodeToString(ephemeral), b64.EncodeToString(wrapped))
+	}
+	fmt.Fprintf(&buf, "%s%s\n", tagPrefix, RecipientsTag(recipients))
+	buf.WriteString(headerEnd + "\n")
+
+	// The header is authenticated, so that recipients cannot be changed.
+	payload, err := seal(fileKey, data, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(b64.EncodeToString(payload))
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// RecipientsTag returns a short hash of recipients, it is stored in the
+// encrypted header to tell if the recipients are changed. The order of
+// recipients does not matter.
+func RecipientsTag(recipients []*Recipient) string {
+	keys := make([]string, len(recipients))
+	for i, r := range recipients {
+		keys[i] = r.String()
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// EncryptedRecipientsTag returns the recipients tag in encrypted data, it
+// is empty if not found.
+func EncryptedRecipientsTag(data []byte) string {
+	if !IsRecipientEncrypted(data) {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == headerEnd {
+			break
+		}
+		if tag, ok := strings.CutPrefix(line, tagPrefix); ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+func DecryptIdentities(data []byte, identities []*Identity) ([]byte, error) {
+	if !IsRecipientEncrypted(data) {
+		return nil, errors.New("data is not encrypted by recipients")
+	}
+	idx := bytes.Index(data, []byte("\n"+headerEnd+"\n"))
+	if idx < 0 {
+		return nil, errors.New("encrypted data is bad format")
+	}
+	header := data[:idx+len(headerEnd)+2]
+	payload, err := b64.DecodeString(strings.TrimSpace(string(data[len(header):])))
+	if err != nil {
+		return nil, errors.New("encrypted payload is not valid base64")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(header))
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), stanzaPrefix)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New("encrypted recipient is bad format")
+		}
+		ephemeral, err := b64.DecodeString(fields[0])
+		if err != nil {
+			return nil, errors.New("encrypted recipient is bad format")
+		}
+		wrapped, err := b64.DecodeString(fields[1])
+		if err != nil {
+			return nil, errors.New("encrypted recipient is bad format")
+		}
+		for _, id := range identities {
+			fileKey, err := id.unwrap(ephemeral, wrapped)
+			if err != nil {
+				continue
+			}
+			result, err := open(fileKey, payload, header)
+			if err != nil {
+				return nil, errors.New("encrypted data was modified")
+			}
+			return result, nil
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+func (r *Recipient) wrap(fileKey []byte) ([]byte, []byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, nil, errors.Trace(err, "generate ephemeral key")
+	}
+	shared, err := ephemeral.ECDH(r.key)
+	if err != nil {
+		return nil, nil, errors.Trace(err, "x25519")
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	wrapKey, err := deriveWrapKey(shared, ephemeralPub, r.key.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := seal(wrapKey, fileKey, nil)
+	return ephemeralPub, wrapped, err
+}
+
+func (id *Identity) unwrap(ephemeral, wrapped []byte) ([]byte, error) {
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := id.key.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	wrapKey, err := deriveWrapKey(shared, ephemeral, id.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return open(wrapKey, wrapped, nil)
+}
+
+func deriveWrapKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeral)+len(recipient))
+	salt = append(salt, ephemeral...)
+	salt = append(salt, recipient...)
+	key := make([]byte, fileKeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), key)
+	return key, errors.Trace(err, "derive wrap key")
+}
+
+func seal(key, data, ad []byte) ([]byte, error) {
+	gcm, err := initGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(crand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Trace(err, "generate random sequence")
+	}
+	return gcm.Seal(nonce, nonce, data, ad), nil
+}
+
+func open(key, data, ad []byte) ([]byte, error) {
+	gcm, err := initGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("value is bad format")
+	}
+	return gcm.Open(nil, data[:nonceSize], data[nonceSize:], ad)
+}
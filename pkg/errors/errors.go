@@ -10,6 +10,10 @@ func New(msg string) error {
 	return errors.New(msg)
 }
 
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
 type Error struct {
 	stack []string
 	err   error
@@ -40,6 +44,10 @@ func (err *Error) Error() string {
 	return fmt.Sprintf("%s: %v", op, err.err)
 }
 
+func (err *Error) Unwrap() error {
+	return err.err
+}
+
 func (err *Error) Extra() {
 	if ext, ok := err.err.(Extra); ok {
 		ext.Extra()
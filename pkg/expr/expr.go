@@ -0,0 +1,456 @@
+package expr
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/fioncat/gitzombie/pkg/errors"
+)
+
+// The expression language is used to decide whether to run a job, such as:
+//
+//	exists("go.mod") && branch == "main"
+//	!match(env.GOOS, "darwin") || result("build") == "failure"
+//
+// The values are strings or bools. Supported syntax:
+//
+//	"str", 'str'       string literal
+//	true, false        bool literal
+//	name, env.NAME     variable, provided by Context
+//	fn(arg, ...)       function call, provided by Context, or builtin
+//	== !=              compare two values
+//	! && ||            logical operators
+//	( )                grouping
+//
+// The builtin function "match(value, pattern...)" reports whether the value
+// matches any of the glob patterns.
+//
+// A string is true if it is not empty.
+
+// Context provides variables and functions for expression.
+type Context interface {
+	Var(name string) (any, error)
+	Call(name string, args []any) (any, error)
+}
+
+type Expr struct {
+	raw  string
+	root node
+}
+
+// Parse parses the expression, the syntax error is returned here, so it can
+// be checked before running.
+func Parse(s string) (*Expr, error) {
+	p := &parser{lex: &lexer{src: s}}
+	err := p.next()
+	if err != nil {
+		return nil, p.wrap(err)
+	}
+	if p.tok.kind == tokenEOF {
+		return nil, errors.New("expression is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, p.wrap(err)
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.wrap(fmt.Errorf("unexpected %s", p.tok))
+	}
+	return &Expr{raw: s, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.raw
+}
+
+// Eval evaluates the expression and converts the result to bool.
+func (e *Expr) Eval(ctx Context) (bool, error) {
+	val, err := e.root.eval(ctx)
+	if err != nil {
+		return false, fmt.Errorf("eval %q: %v", e.raw, err)
+	}
+	return toBool(val), nil
+}
+
+func toBool(val any) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	return val != nil
+}
+
+func toString(val any) string {
+	if val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+func equal(left, right any) bool {
+	lb, lok := left.(bool)
+	rb, rok := right.(bool)
+	if lok && rok {
+		return lb == rb
+	}
+	return toString(left) == toString(right)
+}
+
+type node interface {
+	eval(ctx Context) (any, error)
+}
+
+type literalNode struct {
+	val any
+}
+
+func (n *literalNode) eval(Context) (any, error) {
+	return n.val, nil
+}
+
+type varNode struct {
+	name string
+}
+
+func (n *varNode) eval(ctx Context) (any, error) {
+	return ctx.Var(n.name)
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n *callNode) eval(ctx Context) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+	if n.name == "match" {
+		return match(args)
+	}
+	return ctx.Call(n.name, args)
+}
+
+func match(args []any) (any, error) {
+	if len(args) < 2 {
+		return nil, errors.New("match requires a value and at least one pattern")
+	}
+	value := toString(args[0])
+	for _, arg := range args[1:] {
+		ok, err := path.Match(toString(arg), value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", toString(arg))
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) eval(ctx Context) (any, error) {
+	val, err := n.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return !toBool(val), nil
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) eval(ctx Context) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit, so that the right side can depend on the left, such
+	// as: exists("go.mod") && result("go-test") == "success"
+	switch n.op {
+	case "&&":
+		if !toBool(left) {
+			return false, nil
+		}
+	case "||":
+		if toBool(left) {
+			return true, nil
+		}
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+	return toBool(right), nil
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) wrap(err error) error {
+	return fmt.Errorf("parse expression %q: %v", p.lex.src, err)
+}
+
+func (p *parser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) expect(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return fmt.Errorf("expect %s, found %s", kind, p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseNot, "&&")
+}
+
+func (p *parser) parseBinary(sub func() (node, error), op string) (node, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokenOp && p.tok.val == op {
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.tok.kind == tokenOp && p.tok.val == "!" {
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenOp && (p.tok.val == "==" || p.tok.val == "!=") {
+		op := p.tok.val
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{val: tok.val}, p.next()
+
+	case tokenLParen:
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(tokenRParen)
+
+	case tokenIdent:
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.val {
+		case "true":
+			return &literalNode{val: true}, nil
+		case "false":
+			return &literalNode{val: false}, nil
+		}
+		if p.tok.kind != tokenLParen {
+			return &varNode{name: tok.val}, nil
+		}
+		return p.parseCall(tok.val)
+	}
+	return nil, fmt.Errorf("unexpected %s", tok)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	call := &callNode{name: name}
+	for p.tok.kind != tokenRParen {
+		if len(call.args) > 0 {
+			err = p.expect(tokenComma)
+			if err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	return call, p.next()
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of expression"
+	case tokenIdent:
+		return "name"
+	case tokenString:
+		return "string"
+	case tokenOp:
+		return "operator"
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenComma:
+		return `","`
+	}
+	return "unknown"
+}
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenIdent, tokenOp:
+		return fmt.Sprintf("%q", t.val)
+	case tokenString:
+		return fmt.Sprintf("string %q", t.val)
+	}
+	return t.kind.String()
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF}, nil
+	}
+	rest := l.src[l.pos:]
+	ch := rest[0]
+	switch ch {
+	case '(':
+		l.pos++
+		return token{kind: tokenLParen}, nil
+	case ')':
+		l.pos++
+		return token{kind: tokenRParen}, nil
+	case ',':
+		l.pos++
+		return token{kind: tokenComma}, nil
+
+	case '"', '\'':
+		end := strings.IndexByte(rest[1:], ch)
+		if end < 0 {
+			return token{}, errors.New("unterminated string")
+		}
+		l.pos += end + 2
+		return token{kind: tokenString, val: rest[1 : end+1]}, nil
+	}
+
+	for _, op := range []string{"==", "!=", "&&", "||", "!"} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			return token{kind: tokenOp, val: op}, nil
+		}
+	}
+
+	if isIdentChar(ch) {
+		end := 1
+		for end < len(rest) && isIdentChar(rest[end]) {
+			end++
+		}
+		l.pos += end
+		return token{kind: tokenIdent, val: rest[:end]}, nil
+	}
+	return token{}, fmt.Errorf("unexpected character %q", ch)
+}
+
+func isIdentChar(ch byte) bool {
+	return ch == '_' || ch == '.' || ch == '-' ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+		(ch >= '0' && ch <= '9')
+}
@@ -0,0 +1,81 @@
+package expr
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testContext map[string]any
+
+func (ctx testContext) Var(name string) (any, error) {
+	val, ok := ctx[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", name)
+	}
+	return val, nil
+}
+
+func (ctx testContext) Call(name string, args []any) (any, error) {
+	switch name {
+	case "exists":
+		return args[0] == "go.mod", nil
+	case "fail":
+		return nil, fmt.Errorf("should not be called")
+	}
+	return nil, fmt.Errorf("unknown function %q", name)
+}
+
+func TestEval(t *testing.T) {
+	ctx := testContext{
+		"branch":   "release/v1",
+		"remote":   "github",
+		"env.CI":   "",
+		"env.ARCH": "amd64",
+	}
+	cases := []struct {
+		expr   string
+		expect bool
+	}{
+		{`exists("go.mod")`, true},
+		{`exists('Cargo.toml')`, false},
+		{`branch == "main"`, false},
+		{`match(branch, "main", "release/*")`, true},
+		{`remote == "github" && env.ARCH != "arm64"`, true},
+		{`!env.CI`, true},
+		{`env.CI || env.ARCH`, true},
+		{`false && fail()`, false},
+		{`true || fail()`, true},
+		{`!(remote == "gitlab" || exists("package.json"))`, true},
+		{`exists("go.mod") == true`, true},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := e.Eval(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expect {
+			t.Fatalf("%s: expect %v, found %v", c.expr, c.expect, result)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`exists("go.mod"`,
+		`branch ==`,
+		`"unterminated`,
+		`a b`,
+		`a = b`,
+		`fn(a,)`,
+	} {
+		_, err := Parse(s)
+		if err == nil {
+			t.Fatalf("expect error for %q", s)
+		}
+	}
+}
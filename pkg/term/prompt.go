@@ -3,9 +3,11 @@ package term
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/jedib0t/go-pretty/v6/text"
@@ -16,6 +18,22 @@ var (
 	AlwaysYes bool
 )
 
+// The fd to read password from, see UseTTY.
+var passwordFd = syscall.Stdin
+
+// UseTTY reads user input from the controlling terminal instead of stdin.
+// This is used when stdin is occupied by other program, like git credential
+// helper protocol.
+func UseTTY() error {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return fmt.Errorf("failed to open tty: %v", err)
+	}
+	os.Stdin = tty
+	passwordFd = int(tty.Fd())
+	return nil
+}
+
 func Confirm(msg string, args ...any) bool {
 	if AlwaysYes {
 		return true
@@ -54,7 +72,7 @@ func Open(url string) error {
 func InputPassword(msg string, args ...any) (string, error) {
 	msg = fmt.Sprintf(msg, args...)
 	fmt.Fprintf(os.Stderr, "%s: ", msg)
-	bytesPassword, err := term.ReadPassword(syscall.Stdin)
+	bytesPassword, err := term.ReadPassword(passwordFd)
 	if err != nil {
 		return "", fmt.Errorf("failed to read password: %v", err)
 	}
@@ -79,6 +97,32 @@ func InputNewPassword(msg string, args ...any) (string, error) {
 	return password, nil
 }
 
+// Input reads a line from user. The stdin is read byte by byte, so that the
+// later prompts can still read the remaining input.
+func Input(msg string, args ...any) (string, error) {
+	msg = fmt.Sprintf(msg, args...)
+	fmt.Fprintf(os.Stderr, "%s: ", msg)
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return "", fmt.Errorf("failed to read input: %v", err)
+		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
 func InputErase(msg string, args ...any) string {
 	msg = fmt.Sprintf(msg, args...)
 	fmt.Fprintf(os.Stderr, "%s: ", msg)
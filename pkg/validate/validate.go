@@ -14,7 +14,7 @@ import (
 // Use for generating enum validators.
 var enumMap = map[string][]string{
 	"protocol": {"https", "ssh"},
-	"provider": {"github", "gitlab"},
+	"provider": {"github", "gitlab", "bitbucket", "gerrit"},
 }
 
 var (
@@ -101,8 +101,11 @@ func (t *jobTracker[T]) render() {
 
 	verbHead := term.Style(t.verb, "yellow")
 	for _, task := range running {
-		line := fmt.Sprintf("%s %s\n", verbHead, task.Name)
-		out.WriteString(line)
+		line := fmt.Sprintf("%s %s", verbHead, task.Name)
+		if status := task.Status(); status != "" {
+			line = fmt.Sprintf("%s %s", line, status)
+		}
+		out.WriteString(line + "\n")
 	}
 	fmt.Fprint(os.Stderr, out.String())
 }
@@ -26,6 +26,23 @@ type Task[T any] struct {
 
 	done bool
 	fail bool
+
+	statusLock sync.Mutex
+	status     string
+}
+
+// SetStatus sets the progress of task, it is shown after the task name by
+// tracker.
+func (task *Task[T]) SetStatus(status string) {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	task.status = status
+}
+
+func (task *Task[T]) Status() string {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return task.status
 }
 
 type Tracker[T any] interface {